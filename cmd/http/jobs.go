@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/rudsonalves/quicknotes/internal/mailer"
+	"github.com/rudsonalves/quicknotes/internal/models"
+	"github.com/rudsonalves/quicknotes/internal/ratelimit"
+	"github.com/rudsonalves/quicknotes/internal/render"
+	"github.com/rudsonalves/quicknotes/internal/repositories"
+	"github.com/rudsonalves/quicknotes/internal/storage"
+)
+
+const (
+	trashPurgeInterval = time.Hour
+
+	storageSweepInterval = 24 * time.Hour
+	// storageSweepGrace keeps files of uploads whose record is still
+	// being written from being swept.
+	storageSweepGrace = time.Hour
+
+	attemptsPurgeInterval = time.Hour
+	tokenCleanupInterval  = time.Hour
+	// attemptsRetention is longer than any window or lockout of the
+	// limiters, so purged keys had nothing left to count.
+	attemptsRetention = 24 * time.Hour
+
+	reminderInterval  = time.Minute
+	reminderBatchSize = 50
+	// reminderPreviewLength is how much of the note content goes in the
+	// reminder mail.
+	reminderPreviewLength = 300
+)
+
+// runPeriodically calls job right away and then on every interval until
+// ctx is done.
+func runPeriodically(ctx context.Context, interval time.Duration, job func(ctx context.Context)) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			job(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// startTrashPurge removes notes kept in the trash for longer than
+// retention.
+func startTrashPurge(ctx context.Context, noteRepo repositories.NoteRepository, retention time.Duration) {
+	runPeriodically(ctx, trashPurgeInterval, func(ctx context.Context) {
+		count, err := noteRepo.PurgeTrash(ctx, time.Now().Add(-retention))
+		if err != nil {
+			slog.Error(err.Error())
+			return
+		}
+		if count > 0 {
+			slog.Info(fmt.Sprintf("%d notes purged from trash", count))
+		}
+	})
+}
+
+// startStorageSweep removes stored files no attachment points to anymore,
+// such as the files of notes purged from the trash.
+func startStorageSweep(ctx context.Context, attachmentRepo repositories.AttachmentRepository, fileStorage storage.Storage) {
+	runPeriodically(ctx, storageSweepInterval, func(ctx context.Context) {
+		count := 0
+		err := fileStorage.Walk(ctx, func(key string, modTime time.Time) error {
+			if time.Since(modTime) < storageSweepGrace {
+				return nil
+			}
+			exists, err := attachmentRepo.Exists(ctx, key)
+			if err != nil || exists {
+				return err
+			}
+			if err := fileStorage.Delete(ctx, key); err != nil {
+				return err
+			}
+			count++
+			return nil
+		})
+		if err != nil {
+			slog.Error(err.Error())
+		}
+		if count > 0 {
+			slog.Info(fmt.Sprintf("%d orphan files removed from storage", count))
+		}
+	})
+}
+
+// startAttemptsPurge forgets the failed signins too old to count.
+func startAttemptsPurge(ctx context.Context, attemptStore ratelimit.Store) {
+	runPeriodically(ctx, attemptsPurgeInterval, func(ctx context.Context) {
+		count, err := attemptStore.Purge(ctx, time.Now().Add(-attemptsRetention))
+		if err != nil {
+			slog.Error(err.Error())
+			return
+		}
+		if count > 0 {
+			slog.Debug(fmt.Sprintf("%d expired login attempts purged", count))
+		}
+	})
+}
+
+// startTokenCleanup removes the expired confirmation and password reset
+// tokens.
+func startTokenCleanup(ctx context.Context, userRepo repositories.UserRepository) {
+	runPeriodically(ctx, tokenCleanupInterval, func(ctx context.Context) {
+		count, err := userRepo.DeleteExpiredTokens(ctx, time.Now())
+		if err != nil {
+			slog.Error(err.Error())
+			return
+		}
+		if count > 0 {
+			slog.Info(fmt.Sprintf("%d expired user tokens removed", count))
+		}
+	})
+}
+
+// startReminderScheduler mails the owners of notes whose reminder is due.
+// Reminders are marked as sent before the mail goes out and released
+// again when sending fails, so they are never sent twice.
+func startReminderScheduler(ctx context.Context, noteRepo repositories.NoteRepository, mail mailer.MailService, baseURL string) {
+	runPeriodically(ctx, reminderInterval, func(ctx context.Context) {
+		for {
+			reminders, err := noteRepo.ClaimDueReminders(ctx, time.Now(), reminderBatchSize)
+			if err != nil {
+				slog.Error(err.Error())
+				return
+			}
+
+			// released reminders are left for the next run, or they would
+			// be claimed again right away
+			failed := false
+			for _, reminder := range reminders {
+				if err := sendReminder(mail, baseURL, reminder); err != nil {
+					failed = true
+					slog.Error(fmt.Sprintf("reminder of note %d not sent: %s", reminder.NoteId.Int, err))
+					if err := noteRepo.ReleaseReminder(ctx, reminder.NoteId.Int.Int64()); err != nil {
+						slog.Error(err.Error())
+					}
+				}
+			}
+
+			if failed || len(reminders) < reminderBatchSize {
+				return
+			}
+		}
+	})
+}
+
+func sendReminder(mail mailer.MailService, baseURL string, reminder models.Reminder) error {
+	content := reminder.Content.String
+	if utf8.RuneCountInString(content) > reminderPreviewLength {
+		content = string([]rune(content)[:reminderPreviewLength]) + "…"
+	}
+
+	body, err := render.RenderMail(baseURL, "reminder.html", map[string]string{
+		"noteId":   strconv.FormatInt(reminder.NoteId.Int.Int64(), 10),
+		"title":    reminder.Title.String,
+		"content":  content,
+		"remindAt": reminder.RemindAt.Time.In(models.Location(reminder.Timezone.String)).Format("02/01/2006 15:04"),
+	})
+	if err != nil {
+		return err
+	}
+
+	return mail.Send(mailer.MailMessage{
+		To:      []string{reminder.Email.String},
+		Subject: "Lembrete: " + reminder.Title.String,
+		IsHtml:  true,
+		Body:    body,
+	})
+}
@@ -8,12 +8,18 @@ import (
 	"os"
 	"strconv"
 	"time"
+	// the time zones of the users, for systems without a zoneinfo database
+	_ "time/tzdata"
 
 	"github.com/alexedwards/scs/pgxstore"
 	"github.com/alexedwards/scs/v2"
 	"github.com/gorilla/csrf"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/rudsonalves/quicknotes/internal/handlers"
 	"github.com/rudsonalves/quicknotes/internal/mailer"
+	"github.com/rudsonalves/quicknotes/internal/ratelimit"
+	"github.com/rudsonalves/quicknotes/internal/repositories"
+	"github.com/rudsonalves/quicknotes/internal/storage"
 )
 
 func main() {
@@ -47,7 +53,33 @@ func main() {
 
 	csrfMiddleware := csrf.Protect([]byte(config.CSRFKey))
 
-	mux := LoadRoutes(dbPool, sessionManager, mailservice)
+	fileStorage, err := storage.NewLocalStorage(config.UploadDir)
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(1)
+	}
+
+	var attemptStore ratelimit.Store
+	switch config.AttemptsStore {
+	case "postgres":
+		attemptStore = ratelimit.NewPostgresStore(dbPool)
+	case "memory":
+		attemptStore = ratelimit.NewMemoryStore()
+	default:
+		slog.Warn(fmt.Sprintf("invalid QNS_ATTEMPTS_STORE %q, using memory", config.AttemptsStore))
+		attemptStore = ratelimit.NewMemoryStore()
+	}
+
+	// Background jobs
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	defer stopJobs()
+	startTrashPurge(jobsCtx, repositories.NewNoteRepository(dbPool), config.TrashRetention())
+	startStorageSweep(jobsCtx, repositories.NewAttachmentRepository(dbPool), fileStorage)
+	startReminderScheduler(jobsCtx, repositories.NewNoteRepository(dbPool), mailservice, config.BaseURL)
+	startAttemptsPurge(jobsCtx, attemptStore)
+	startTokenCleanup(jobsCtx, repositories.NewUserRepository(dbPool))
+
+	mux := LoadRoutes(dbPool, sessionManager, mailservice, fileStorage, attemptStore)
 
 	addr := fmt.Sprintf(":%s", config.ServerPort)
 	slog.Info(fmt.Sprintf("Server running in %s", addr))
@@ -55,12 +87,13 @@ func main() {
 	// 	addr,
 	// 	"cer.cer",
 	// 	"cer.key",
-	// 	sessionManager.LoadAndSave(csrfMiddleware(mux))); err != nil {
+	// 	sessionManager.LoadAndSave(handlers.SkipCSRFForBearer(csrfMiddleware(mux)))); err != nil {
 	// 	panic(err)
 	// }
+	forwardedFor := handlers.ForwardedFor(config.TrustedProxyPrefixes())
 	if err := http.ListenAndServe(
 		addr,
-		sessionManager.LoadAndSave(csrfMiddleware(mux))); err != nil {
+		forwardedFor(sessionManager.LoadAndSave(handlers.LimitRequestBody(handlers.SkipCSRFForBearer(csrfMiddleware(mux)))))); err != nil {
 		panic(err)
 	}
 }
@@ -9,15 +9,20 @@ import (
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/rudsonalves/quicknotes/internal/handlers"
 	"github.com/rudsonalves/quicknotes/internal/mailer"
+	"github.com/rudsonalves/quicknotes/internal/models"
+	"github.com/rudsonalves/quicknotes/internal/ratelimit"
 	"github.com/rudsonalves/quicknotes/internal/render"
 	"github.com/rudsonalves/quicknotes/internal/repositories"
+	"github.com/rudsonalves/quicknotes/internal/storage"
 	"github.com/rudsonalves/quicknotes/views"
 )
 
 func LoadRoutes(
 	dbPool *pgxpool.Pool,
 	sessionManager *scs.SessionManager,
-	mailservice mailer.MailService) http.Handler {
+	mailservice mailer.MailService,
+	fileStorage storage.Storage,
+	attemptStore ratelimit.Store) http.Handler {
 	mux := http.NewServeMux()
 
 	staticFS, err := fs.Sub(views.Files, "static")
@@ -32,29 +37,87 @@ func LoadRoutes(
 
 	noteRepo := repositories.NewNoteRepository(dbPool)
 	userRepo := repositories.NewUserRepository(dbPool)
-
-	render := render.NewRender(sessionManager)
-
-	noteHandler := handlers.NewNoteHandler(sessionManager, noteRepo, render)
-	userHandler := handlers.NewUserHandler(sessionManager, userRepo, render, mailservice)
-
-	authMidd := handlers.NewAuthMiddleware(sessionManager)
+	tokenRepo := repositories.NewAPITokenRepository(dbPool)
+	shareRepo := repositories.NewNoteShareRepository(dbPool)
+	linkRepo := repositories.NewNoteLinkRepository(dbPool)
+	notebookRepo := repositories.NewNotebookRepository(dbPool)
+	attachmentRepo := repositories.NewAttachmentRepository(dbPool)
+	checklistRepo := repositories.NewChecklistRepository(dbPool)
+	templateRepo := repositories.NewNoteTemplateRepository(dbPool)
+
+	render := render.NewRender(sessionManager, notebookRepo)
+
+	noteHandler := handlers.NewNoteHandler(sessionManager, noteRepo, shareRepo, linkRepo, notebookRepo, attachmentRepo, checklistRepo, templateRepo, fileStorage, render, attemptStore)
+	userHandler := handlers.NewUserHandler(sessionManager, userRepo, render, mailservice, attemptStore)
+	notebookHandler := handlers.NewNotebookHandler(sessionManager, notebookRepo, render)
+	templateHandler := handlers.NewNoteTemplateHandler(sessionManager, templateRepo, render)
+	apiNoteHandler := handlers.NewAPINoteHandler(noteRepo)
+	apiTokenHandler := handlers.NewAPITokenHandler(sessionManager, tokenRepo, render)
+
+	authMidd := handlers.NewAuthMiddleware(sessionManager, tokenRepo)
 	errorMidd := handlers.NewErrorHandlerMiddleware(render)
 
 	mux.HandleFunc("GET /", handlers.NewHomeHandler(render).HomeHandler)
 
+	// public read-only links, no authentication required
+	mux.Handle("GET /s/{token}", errorMidd.HandleError(noteHandler.NotePublic))
+	mux.Handle("POST /s/{token}", errorMidd.HandleError(noteHandler.NotePublicUnlock))
+
 	mux.Handle("GET /note", authMidd.RequireAuth(errorMidd.HandleError(noteHandler.NoteList)))
 	mux.Handle("GET /note/{id}", authMidd.RequireAuth(errorMidd.HandleError(noteHandler.NoteView)))
 	mux.Handle("GET /note/new", authMidd.RequireAuth(errorMidd.HandleError(noteHandler.NoteNew)))
 	mux.Handle("POST /note", authMidd.RequireAuth(errorMidd.HandleError(noteHandler.NoteSave)))
 	mux.Handle("DELETE /note/{id}", authMidd.RequireAuth(errorMidd.HandleError(noteHandler.NoteDelete)))
+	mux.Handle("GET /note/import", authMidd.RequireAuth(errorMidd.HandleError(noteHandler.NoteImportForm)))
+	mux.Handle("POST /note/import", authMidd.RequireAuth(errorMidd.HandleError(noteHandler.NoteImport)))
+	mux.Handle("GET /note/export", authMidd.RequireAuth(errorMidd.HandleError(noteHandler.NoteExport)))
+	mux.Handle("GET /note/archive", authMidd.RequireAuth(errorMidd.HandleError(noteHandler.NoteArchive)))
+	mux.Handle("POST /note/{id}/pin", authMidd.RequireAuth(errorMidd.HandleError(noteHandler.NotePin)))
+	mux.Handle("POST /note/{id}/archive", authMidd.RequireAuth(errorMidd.HandleError(noteHandler.NoteArchiveToggle)))
+	mux.Handle("POST /note/{id}/shares", authMidd.RequireAuth(errorMidd.HandleError(noteHandler.NoteShare)))
+	mux.Handle("DELETE /note/{id}/shares/{share}", authMidd.RequireAuth(errorMidd.HandleError(noteHandler.NoteUnshare)))
+	mux.Handle("POST /note/{id}/links", authMidd.RequireAuth(errorMidd.HandleError(noteHandler.NoteLinkCreate)))
+	mux.Handle("DELETE /note/{id}/links/{link}", authMidd.RequireAuth(errorMidd.HandleError(noteHandler.NoteLinkRevoke)))
+	mux.Handle("POST /note/{id}/attachments", authMidd.RequireAuth(errorMidd.HandleError(noteHandler.NoteAttachmentUpload)))
+	mux.Handle("GET /note/{id}/attachments/{attachment}", authMidd.RequireAuth(errorMidd.HandleError(noteHandler.NoteAttachmentDownload)))
+	mux.Handle("DELETE /note/{id}/attachments/{attachment}", authMidd.RequireAuth(errorMidd.HandleError(noteHandler.NoteAttachmentDelete)))
+	mux.Handle("POST /note/{id}/items/{item}/toggle", authMidd.RequireAuth(errorMidd.HandleError(noteHandler.NoteChecklistToggle)))
+	mux.Handle("POST /note/{id}/items/{item}/move", authMidd.RequireAuth(errorMidd.HandleError(noteHandler.NoteChecklistMove)))
+	mux.Handle("GET /note/trash", authMidd.RequireAuth(errorMidd.HandleError(noteHandler.NoteTrash)))
+	mux.Handle("DELETE /note/trash", authMidd.RequireAuth(errorMidd.HandleError(noteHandler.NoteTrashEmpty)))
+	mux.Handle("POST /note/trash/{id}/restore", authMidd.RequireAuth(errorMidd.HandleError(noteHandler.NoteTrashRestore)))
+	mux.Handle("DELETE /note/trash/{id}", authMidd.RequireAuth(errorMidd.HandleError(noteHandler.NoteTrashDelete)))
 	mux.Handle("GET /note/{id}/edit", authMidd.RequireAuth(errorMidd.HandleError(noteHandler.NoteEdit)))
+	mux.Handle("GET /note/{id}/history", authMidd.RequireAuth(errorMidd.HandleError(noteHandler.NoteHistory)))
+	mux.Handle("GET /note/{id}/diff", authMidd.RequireAuth(errorMidd.HandleError(noteHandler.NoteDiff)))
+	mux.Handle("POST /note/{id}/history/{revision}/restore", authMidd.RequireAuth(errorMidd.HandleError(noteHandler.NoteRestore)))
+
+	mux.Handle("GET /notebooks", authMidd.RequireAuth(errorMidd.HandleError(notebookHandler.NotebookList)))
+	mux.Handle("POST /notebooks", authMidd.RequireAuth(errorMidd.HandleError(notebookHandler.NotebookCreate)))
+	mux.Handle("GET /notebooks/{id}/edit", authMidd.RequireAuth(errorMidd.HandleError(notebookHandler.NotebookEdit)))
+	mux.Handle("POST /notebooks/{id}", authMidd.RequireAuth(errorMidd.HandleError(notebookHandler.NotebookUpdate)))
+	mux.Handle("DELETE /notebooks/{id}", authMidd.RequireAuth(errorMidd.HandleError(notebookHandler.NotebookDelete)))
+
+	mux.Handle("GET /templates", authMidd.RequireAuth(errorMidd.HandleError(templateHandler.TemplateList)))
+	mux.Handle("POST /templates", authMidd.RequireAuth(errorMidd.HandleError(templateHandler.TemplateCreate)))
+	mux.Handle("GET /templates/{id}/edit", authMidd.RequireAuth(errorMidd.HandleError(templateHandler.TemplateEdit)))
+	mux.Handle("POST /templates/{id}", authMidd.RequireAuth(errorMidd.HandleError(templateHandler.TemplateUpdate)))
+	mux.Handle("DELETE /templates/{id}", authMidd.RequireAuth(errorMidd.HandleError(templateHandler.TemplateDelete)))
+
+	mux.Handle("GET /api/v1/notes", authMidd.RequireAPIAuth(models.ScopeNotesRead, errorMidd.HandleAPIError(apiNoteHandler.List)))
+	mux.Handle("POST /api/v1/notes", authMidd.RequireAPIAuth(models.ScopeNotesWrite, errorMidd.HandleAPIError(apiNoteHandler.Create)))
+	mux.Handle("GET /api/v1/notes/{id}", authMidd.RequireAPIAuth(models.ScopeNotesRead, errorMidd.HandleAPIError(apiNoteHandler.Get)))
+	mux.Handle("PUT /api/v1/notes/{id}", authMidd.RequireAPIAuth(models.ScopeNotesWrite, errorMidd.HandleAPIError(apiNoteHandler.Update)))
+	mux.Handle("PATCH /api/v1/notes/{id}", authMidd.RequireAPIAuth(models.ScopeNotesWrite, errorMidd.HandleAPIError(apiNoteHandler.Update)))
+	mux.Handle("DELETE /api/v1/notes/{id}", authMidd.RequireAPIAuth(models.ScopeNotesWrite, errorMidd.HandleAPIError(apiNoteHandler.Delete)))
 
 	mux.Handle("GET /user/signup", errorMidd.HandleError(userHandler.SignupForm))
 	mux.Handle("POST /user/signup", errorMidd.HandleError(userHandler.Signup))
 
 	mux.Handle("GET /user/signin", errorMidd.HandleError(userHandler.SigninForm))
 	mux.Handle("POST /user/signin", errorMidd.HandleError(userHandler.Signin))
+	mux.Handle("GET /user/signin/2fa", errorMidd.HandleError(userHandler.SigninCodeForm))
+	mux.Handle("POST /user/signin/2fa", errorMidd.HandleError(userHandler.SigninCode))
 
 	mux.Handle("GET /user/signout", errorMidd.HandleError(userHandler.Signout))
 
@@ -64,11 +127,22 @@ func LoadRoutes(
 	mux.Handle("GET /user/password/{token}", errorMidd.HandleError(userHandler.ResetPasswordForm))
 
 	mux.Handle("GET /me", authMidd.RequireAuth(errorMidd.HandleError(userHandler.Me)))
+	mux.Handle("POST /me", authMidd.RequireAuth(errorMidd.HandleError(userHandler.AccountUpdate)))
+	mux.Handle("POST /me/email", authMidd.RequireAuth(errorMidd.HandleError(userHandler.AccountEmail)))
+	mux.Handle("GET /me/email/{token}", errorMidd.HandleError(userHandler.ConfirmEmailChange))
+	mux.Handle("POST /me/password", authMidd.RequireAuth(errorMidd.HandleError(userHandler.AccountPassword)))
+	mux.Handle("GET /me/tokens", authMidd.RequireAuth(errorMidd.HandleError(apiTokenHandler.TokenList)))
+	mux.Handle("POST /me/tokens", authMidd.RequireAuth(errorMidd.HandleError(apiTokenHandler.TokenCreate)))
+	mux.Handle("DELETE /me/tokens/{id}", authMidd.RequireAuth(errorMidd.HandleError(apiTokenHandler.TokenRevoke)))
+	mux.Handle("GET /me/2fa", authMidd.RequireAuth(errorMidd.HandleError(userHandler.TwoFactor)))
+	mux.Handle("POST /me/2fa", authMidd.RequireAuth(errorMidd.HandleError(userHandler.TwoFactorEnable)))
+	mux.Handle("POST /me/2fa/disable", authMidd.RequireAuth(errorMidd.HandleError(userHandler.TwoFactorDisable)))
+	mux.Handle("POST /me/2fa/recovery-codes", authMidd.RequireAuth(errorMidd.HandleError(userHandler.TwoFactorRecoveryCodes)))
 
 	mux.Handle("GET /confirmation/{token}", errorMidd.HandleError(userHandler.Confirm))
 
-	// mux.Handle("GET /confirmation", handlers.HandlerWithError(userHandler.NewConfirmationForm))
-	// mux.Handle("POST /confirmation", handlers.HandlerWithError(userHandler.NewConfirmation))
+	mux.Handle("GET /confirmation", errorMidd.HandleError(userHandler.NewConfirmationForm))
+	mux.Handle("POST /confirmation", errorMidd.HandleError(userHandler.NewConfirmation))
 
 	return mux
 }
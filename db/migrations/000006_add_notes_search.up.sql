@@ -0,0 +1,7 @@
+ALTER TABLE notes ADD COLUMN IF NOT EXISTS search tsvector
+  GENERATED ALWAYS AS (
+    setweight(to_tsvector('portuguese', coalesce(title, '')), 'A') ||
+    setweight(to_tsvector('portuguese', coalesce(content, '')), 'B')
+  ) STORED;
+
+CREATE INDEX IF NOT EXISTS notes_search_idx ON notes USING GIN (search);
@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS note_revisions (
+  id BIGSERIAL PRIMARY KEY,
+  note_id BIGINT NOT NULL REFERENCES notes(id) ON DELETE CASCADE,
+  title TEXT NOT NULL,
+  content TEXT,
+  color TEXT NOT NULL,
+  created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS note_revisions_note_id_idx ON note_revisions (note_id);
+
+-- the current state of existing notes becomes their first revision
+INSERT INTO note_revisions (note_id, title, content, color, created_at)
+  SELECT id, title, content, color, coalesce(updated_at, created_at, CURRENT_TIMESTAMP)
+  FROM notes;
@@ -0,0 +1,3 @@
+ALTER TABLE notes DROP COLUMN IF EXISTS archived;
+
+ALTER TABLE notes DROP COLUMN IF EXISTS pinned;
\ No newline at end of file
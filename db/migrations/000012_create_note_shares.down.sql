@@ -0,0 +1 @@
+DROP TABLE IF EXISTS note_shares;
\ No newline at end of file
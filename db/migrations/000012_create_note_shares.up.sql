@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS note_shares (
+  id BIGSERIAL PRIMARY KEY,
+  note_id BIGINT NOT NULL REFERENCES notes(id) ON DELETE CASCADE,
+  user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  role TEXT NOT NULL CHECK (role IN ('viewer', 'editor')),
+  created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+  UNIQUE (note_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS note_shares_user_id_idx ON note_shares (user_id);
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS note_links;
\ No newline at end of file
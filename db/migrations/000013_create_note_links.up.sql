@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS note_links (
+  id BIGSERIAL PRIMARY KEY,
+  note_id BIGINT NOT NULL REFERENCES notes(id) ON DELETE CASCADE,
+  token TEXT NOT NULL UNIQUE,
+  password_hash TEXT,
+  expires_at TIMESTAMP,
+  created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS note_links_note_id_idx ON note_links (note_id);
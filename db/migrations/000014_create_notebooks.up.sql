@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS notebooks (
+  id BIGSERIAL PRIMARY KEY,
+  user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  parent_id BIGINT REFERENCES notebooks(id) ON DELETE CASCADE,
+  name TEXT NOT NULL,
+  created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS notebooks_user_id_idx ON notebooks (user_id);
+
+ALTER TABLE notes ADD COLUMN IF NOT EXISTS notebook_id BIGINT REFERENCES notebooks(id) ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS notes_notebook_id_idx ON notes (notebook_id);
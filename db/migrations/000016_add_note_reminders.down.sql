@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS notes_pending_reminders_idx;
+
+ALTER TABLE notes DROP COLUMN IF EXISTS reminder_sent_at;
+ALTER TABLE notes DROP COLUMN IF EXISTS remind_at;
\ No newline at end of file
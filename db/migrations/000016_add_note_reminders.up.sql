@@ -0,0 +1,5 @@
+ALTER TABLE notes ADD COLUMN IF NOT EXISTS remind_at TIMESTAMPTZ;
+ALTER TABLE notes ADD COLUMN IF NOT EXISTS reminder_sent_at TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS notes_pending_reminders_idx ON notes (remind_at)
+  WHERE remind_at IS NOT NULL AND reminder_sent_at IS NULL;
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS checklist_items;
+
+ALTER TABLE notes DROP COLUMN IF EXISTS kind;
\ No newline at end of file
@@ -0,0 +1,11 @@
+ALTER TABLE notes ADD COLUMN IF NOT EXISTS kind TEXT NOT NULL DEFAULT 'text';
+
+CREATE TABLE IF NOT EXISTS checklist_items (
+  id BIGSERIAL PRIMARY KEY,
+  note_id BIGINT NOT NULL REFERENCES notes(id) ON DELETE CASCADE,
+  text TEXT NOT NULL,
+  checked BOOLEAN NOT NULL DEFAULT FALSE,
+  position INTEGER NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS checklist_items_note_id_idx ON checklist_items (note_id, position);
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS note_templates;
\ No newline at end of file
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS note_templates (
+  id BIGSERIAL PRIMARY KEY,
+  user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  title TEXT NOT NULL,
+  content TEXT NOT NULL DEFAULT '',
+  color TEXT NOT NULL,
+  created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+  updated_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS note_templates_user_id_idx ON note_templates (user_id);
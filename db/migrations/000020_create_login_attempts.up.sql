@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS login_attempts (
+  key TEXT PRIMARY KEY,
+  failures INTEGER NOT NULL DEFAULT 0,
+  first_failure_at TIMESTAMPTZ,
+  last_failure_at TIMESTAMPTZ,
+  locked_until TIMESTAMPTZ
+);
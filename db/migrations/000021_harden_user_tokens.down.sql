@@ -0,0 +1,7 @@
+-- the hashes cannot be turned back into tokens, links sent before stop working
+DROP INDEX IF EXISTS users_conf_tokens_expires_at_idx;
+DROP INDEX IF EXISTS users_conf_tokens_token_hash_idx;
+
+ALTER TABLE users_conf_tokens RENAME COLUMN token_hash TO token;
+ALTER TABLE users_conf_tokens DROP COLUMN IF EXISTS expires_at;
+ALTER TABLE users_conf_tokens DROP COLUMN IF EXISTS purpose;
\ No newline at end of file
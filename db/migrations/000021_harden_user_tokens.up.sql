@@ -0,0 +1,21 @@
+ALTER TABLE users_conf_tokens ADD COLUMN IF NOT EXISTS purpose TEXT NOT NULL DEFAULT 'confirmation';
+ALTER TABLE users_conf_tokens ADD COLUMN IF NOT EXISTS expires_at TIMESTAMPTZ;
+
+-- pending tokens of confirmed users can only be password resets
+UPDATE users_conf_tokens t SET purpose = 'password_reset'
+  FROM users u
+  WHERE u.id = t.user_id AND u.active AND NOT t.confirmed;
+
+UPDATE users_conf_tokens SET expires_at = coalesce(created_at, now()) + CASE purpose
+  WHEN 'password_reset' THEN interval '4 hours'
+  ELSE interval '48 hours' END;
+
+ALTER TABLE users_conf_tokens ALTER COLUMN purpose DROP DEFAULT;
+ALTER TABLE users_conf_tokens ALTER COLUMN expires_at SET NOT NULL;
+
+-- keep only the SHA-256 of the tokens, so links already sent keep working
+UPDATE users_conf_tokens SET token = encode(sha256(convert_to(token, 'UTF8')), 'hex');
+ALTER TABLE users_conf_tokens RENAME COLUMN token TO token_hash;
+
+CREATE UNIQUE INDEX IF NOT EXISTS users_conf_tokens_token_hash_idx ON users_conf_tokens (token_hash);
+CREATE INDEX IF NOT EXISTS users_conf_tokens_expires_at_idx ON users_conf_tokens (expires_at);
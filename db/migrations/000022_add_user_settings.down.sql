@@ -0,0 +1,4 @@
+ALTER TABLE users DROP COLUMN IF EXISTS timezone;
+ALTER TABLE users DROP COLUMN IF EXISTS language;
+ALTER TABLE users DROP COLUMN IF EXISTS pending_email;
+ALTER TABLE users DROP COLUMN IF EXISTS display_name;
\ No newline at end of file
@@ -0,0 +1,26 @@
+-- Unconfirmed accounts whose email differs from another account only in
+-- case never signed in, so they are removed, keeping the oldest one when
+-- none of them was confirmed.
+DELETE FROM users u
+  WHERE NOT u.active
+    AND EXISTS (
+      SELECT 1 FROM users o
+        WHERE lower(o.email) = lower(u.email)
+          AND o.id <> u.id
+          AND (o.active OR o.id < u.id));
+
+-- Confirmed accounts that clash must be merged by hand, since both may
+-- hold notes.
+DO $$
+BEGIN
+  IF EXISTS (SELECT 1 FROM users GROUP BY lower(email) HAVING count(*) > 1) THEN
+    RAISE EXCEPTION 'users with the same email in different case must be merged before this migration';
+  END IF;
+END $$;
+
+UPDATE users SET email = lower(email) WHERE email <> lower(email);
+UPDATE users SET pending_email = lower(pending_email) WHERE pending_email <> lower(pending_email);
+
+-- replaces the plain index that an earlier version of 000022 created
+DROP INDEX IF EXISTS users_lower_email_idx;
+CREATE UNIQUE INDEX users_lower_email_idx ON users (lower(email));
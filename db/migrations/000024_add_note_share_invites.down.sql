@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS note_shares_note_id_email_idx;
+DELETE FROM note_shares WHERE user_id IS NULL;
+ALTER TABLE note_shares DROP CONSTRAINT IF EXISTS note_shares_user_or_email;
+ALTER TABLE note_shares DROP COLUMN IF EXISTS email;
+ALTER TABLE note_shares ALTER COLUMN user_id SET NOT NULL;
@@ -0,0 +1,7 @@
+-- shares with emails that have no account yet wait for the account to be
+-- confirmed, so the owner cannot tell which emails are registered
+ALTER TABLE note_shares ALTER COLUMN user_id DROP NOT NULL;
+ALTER TABLE note_shares ADD COLUMN IF NOT EXISTS email TEXT;
+ALTER TABLE note_shares ADD CONSTRAINT note_shares_user_or_email CHECK ((user_id IS NULL) <> (email IS NULL));
+
+CREATE UNIQUE INDEX IF NOT EXISTS note_shares_note_id_email_idx ON note_shares (note_id, email);
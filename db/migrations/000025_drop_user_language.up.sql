@@ -0,0 +1,2 @@
+-- the interface has a single language, so the preference was never used
+ALTER TABLE users DROP COLUMN IF EXISTS language;
@@ -0,0 +1,115 @@
+package diff
+
+import "strings"
+
+// maxCells bounds the size of the LCS table. Larger inputs are reported
+// as a full replacement of the changed block.
+const maxCells = 4_000_000
+
+type Op int
+
+const (
+	Equal Op = iota
+	Insert
+	Delete
+)
+
+func (o Op) String() string {
+	switch o {
+	case Insert:
+		return "insert"
+	case Delete:
+		return "delete"
+	default:
+		return "equal"
+	}
+}
+
+type Line struct {
+	Op   Op
+	Text string
+}
+
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+}
+
+// Lines computes a line based diff that turns a into b.
+func Lines(a, b string) []Line {
+	la, lb := splitLines(a), splitLines(b)
+
+	// common prefix and suffix are kept out of the LCS table
+	start := 0
+	for start < len(la) && start < len(lb) && la[start] == lb[start] {
+		start++
+	}
+	endA, endB := len(la), len(lb)
+	for endA > start && endB > start && la[endA-1] == lb[endB-1] {
+		endA--
+		endB--
+	}
+
+	var lines []Line
+	for _, text := range la[:start] {
+		lines = append(lines, Line{Op: Equal, Text: text})
+	}
+	lines = append(lines, lcsDiff(la[start:endA], lb[start:endB])...)
+	for _, text := range la[endA:] {
+		lines = append(lines, Line{Op: Equal, Text: text})
+	}
+	return lines
+}
+
+func lcsDiff(a, b []string) (lines []Line) {
+	n, m := len(a), len(b)
+	if n*m > maxCells {
+		for _, text := range a {
+			lines = append(lines, Line{Op: Delete, Text: text})
+		}
+		for _, text := range b {
+			lines = append(lines, Line{Op: Insert, Text: text})
+		}
+		return
+	}
+
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, n+1)
+	for i := range lcs {
+		lcs[i] = make([]int, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < n && j < m {
+		switch {
+		case a[i] == b[j]:
+			lines = append(lines, Line{Op: Equal, Text: a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			lines = append(lines, Line{Op: Delete, Text: a[i]})
+			i++
+		default:
+			lines = append(lines, Line{Op: Insert, Text: b[j]})
+			j++
+		}
+	}
+	for ; i < n; i++ {
+		lines = append(lines, Line{Op: Delete, Text: a[i]})
+	}
+	for ; j < m; j++ {
+		lines = append(lines, Line{Op: Insert, Text: b[j]})
+	}
+	return
+}
@@ -0,0 +1,243 @@
+// Package frontmatter reads and writes Markdown documents headed by a YAML
+// front matter block holding the metadata of a note. Only the small subset
+// of YAML used by that block is supported: scalars, quoted strings and
+// lists of strings.
+package frontmatter
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const delimiter = "---"
+
+var ErrUnterminated = errors.New("front matter sem o delimitador de fechamento")
+
+// timeLayouts are the timestamp formats accepted by Parse.
+var timeLayouts = []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02T15:04:05", "2006-01-02"}
+
+// Meta is the metadata kept in the front matter. Zero times are left out.
+type Meta struct {
+	Title     string
+	Color     string
+	Tags      []string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// Write writes the front matter of meta followed by body.
+func Write(w io.Writer, meta Meta, body string) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteString(delimiter + "\n")
+	writeField(bw, "title", quote(meta.Title))
+	writeField(bw, "color", quote(meta.Color))
+	if len(meta.Tags) > 0 {
+		tags := make([]string, len(meta.Tags))
+		for i, tag := range meta.Tags {
+			tags[i] = quote(tag)
+		}
+		writeField(bw, "tags", "["+strings.Join(tags, ", ")+"]")
+	}
+	if !meta.CreatedAt.IsZero() {
+		writeField(bw, "created_at", meta.CreatedAt.Format(time.RFC3339))
+	}
+	if !meta.UpdatedAt.IsZero() {
+		writeField(bw, "updated_at", meta.UpdatedAt.Format(time.RFC3339))
+	}
+	bw.WriteString(delimiter + "\n\n")
+	bw.WriteString(body)
+	if body != "" && !strings.HasSuffix(body, "\n") {
+		bw.WriteString("\n")
+	}
+	return bw.Flush()
+}
+
+func writeField(w *bufio.Writer, key, value string) {
+	w.WriteString(key + ": " + value + "\n")
+}
+
+// quote writes a YAML double quoted scalar. The escapes produced by
+// strconv.Quote are a subset of the ones YAML accepts.
+func quote(value string) string {
+	return strconv.Quote(value)
+}
+
+// Parse splits a document into its front matter and body. Documents that
+// do not start with a front matter are returned whole as the body. Keys
+// other than the ones of Meta are ignored.
+func Parse(document string) (meta Meta, body string, err error) {
+	document = strings.TrimPrefix(document, "\ufeff")
+	document = strings.ReplaceAll(document, "\r\n", "\n")
+	if !strings.HasPrefix(document, delimiter+"\n") {
+		return meta, document, nil
+	}
+
+	var header []string
+	closed := false
+	rest := document[len(delimiter)+1:]
+	for !closed {
+		line, remaining, found := strings.Cut(rest, "\n")
+		if strings.TrimRight(line, " \t") == delimiter {
+			body, closed = remaining, true
+			continue
+		}
+		if !found {
+			return meta, "", ErrUnterminated
+		}
+		header = append(header, line)
+		rest = remaining
+	}
+	body = strings.TrimLeft(body, "\n")
+
+	var listKey string
+	for number, line := range header {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		// items of a block list, as in "tags:\n  - name"
+		if item, ok := strings.CutPrefix(trimmed, "- "); ok && listKey != "" {
+			value, err := scalar(item)
+			if err != nil {
+				return meta, "", fmt.Errorf("linha %d: %w", number+2, err)
+			}
+			if listKey == "tags" {
+				meta.Tags = append(meta.Tags, value)
+			}
+			continue
+		}
+
+		key, value, ok := strings.Cut(trimmed, ":")
+		if !ok {
+			return meta, "", fmt.Errorf("linha %d: esperado \"chave: valor\"", number+2)
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+		listKey = ""
+		if value == "" {
+			listKey = key
+			continue
+		}
+
+		if err := meta.set(key, value); err != nil {
+			return meta, "", fmt.Errorf("linha %d: %w", number+2, err)
+		}
+	}
+
+	return meta, body, nil
+}
+
+func (meta *Meta) set(key, value string) (err error) {
+	switch key {
+	case "title":
+		meta.Title, err = scalar(value)
+	case "color":
+		meta.Color, err = scalar(value)
+	case "tags":
+		meta.Tags, err = list(value)
+	case "created_at":
+		meta.CreatedAt, err = timestamp(value)
+	case "updated_at":
+		meta.UpdatedAt, err = timestamp(value)
+	}
+	return err
+}
+
+// scalar reads a plain, single quoted or double quoted YAML scalar.
+func scalar(value string) (string, error) {
+	if strings.HasPrefix(value, `"`) || strings.HasPrefix(value, "'") {
+		end := closingQuote(value)
+		if end < 0 {
+			return "", fmt.Errorf("texto entre aspas inválido: %s", value)
+		}
+		if rest := strings.TrimSpace(value[end+1:]); rest != "" && !strings.HasPrefix(rest, "#") {
+			return "", fmt.Errorf("texto entre aspas inválido: %s", value)
+		}
+		value = value[:end+1]
+		if value[0] == '\'' {
+			return strings.ReplaceAll(value[1:end], "''", "'"), nil
+		}
+		unquoted, err := strconv.Unquote(value)
+		if err != nil {
+			return "", fmt.Errorf("texto entre aspas inválido: %s", value)
+		}
+		return unquoted, nil
+	}
+	// a comment ends a plain scalar
+	if before, _, found := strings.Cut(value, " #"); found {
+		value = before
+	}
+	return strings.TrimSpace(value), nil
+}
+
+// list reads a flow list, as in [a, "b"], or a single scalar.
+func list(value string) ([]string, error) {
+	if !strings.HasPrefix(value, "[") {
+		item, err := scalar(value)
+		return []string{item}, err
+	}
+	if !strings.HasSuffix(value, "]") {
+		return nil, fmt.Errorf("lista inválida: %s", value)
+	}
+
+	var items []string
+	inner := strings.TrimSpace(value[1 : len(value)-1])
+	for inner != "" {
+		var item string
+		if strings.HasPrefix(inner, `"`) || strings.HasPrefix(inner, "'") {
+			end := closingQuote(inner)
+			if end < 0 {
+				return nil, fmt.Errorf("lista inválida: %s", value)
+			}
+			item, inner = inner[:end+1], inner[end+1:]
+		} else {
+			item, inner, _ = strings.Cut(inner, ",")
+			inner = "," + inner
+		}
+
+		parsed, err := scalar(strings.TrimSpace(item))
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, parsed)
+
+		inner = strings.TrimSpace(inner)
+		inner = strings.TrimSpace(strings.TrimPrefix(inner, ","))
+	}
+	return items, nil
+}
+
+// closingQuote returns the index of the quote closing the string at the
+// start of value, or -1.
+func closingQuote(value string) int {
+	quote := value[0]
+	for i := 1; i < len(value); i++ {
+		switch {
+		case quote == '"' && value[i] == '\\':
+			i++
+		case value[i] == quote && quote == '\'' && i+1 < len(value) && value[i+1] == '\'':
+			i++
+		case value[i] == quote:
+			return i
+		}
+	}
+	return -1
+}
+
+func timestamp(value string) (time.Time, error) {
+	value, err := scalar(value)
+	if err != nil {
+		return time.Time{}, err
+	}
+	for _, layout := range timeLayouts {
+		if parsed, err := time.Parse(layout, value); err == nil {
+			return parsed, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("data inválida: %s", value)
+}
@@ -0,0 +1,235 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	appError "github.com/rudsonalves/quicknotes/internal/app_error"
+	"github.com/rudsonalves/quicknotes/internal/repositories"
+)
+
+var ErrInvalidNoteId = appError.WithStatus(errors.New("id da anotação inválido"), http.StatusBadRequest)
+
+type apiNoteHandler struct {
+	repo repositories.NoteRepository
+}
+
+func NewAPINoteHandler(noteRepo repositories.NoteRepository) *apiNoteHandler {
+	return &apiNoteHandler{repo: noteRepo}
+}
+
+// noteAPIRequest is the JSON body accepted by the notes API. Fields are
+// pointers so PATCH can tell a missing field from an empty one.
+type noteAPIRequest struct {
+	Title   *string   `json:"title"`
+	Content *string   `json:"content"`
+	Color   *string   `json:"color"`
+	Tags    *[]string `json:"tags"`
+	Version int32     `json:"version"`
+}
+
+func (nr noteAPIRequest) value(field *string) string {
+	if field == nil {
+		return ""
+	}
+	return strings.TrimSpace(*field)
+}
+
+// validate checks the request body and normalizes its tags. When partial
+// is false (POST and PUT) title and content are required.
+func (nr noteAPIRequest) validate(partial bool) error {
+	fields := map[string]string{}
+	if !partial || nr.Title != nil {
+		if nr.value(nr.Title) == "" {
+			fields["title"] = "Título é obrigatório"
+		}
+	}
+	if !partial || nr.Content != nil {
+		if nr.value(nr.Content) == "" {
+			fields["content"] = "Conteúdo é obrigatório"
+		}
+	}
+	if nr.Color != nil && !slices.Contains(noteColors(), *nr.Color) {
+		fields["color"] = "Cor inválida"
+	}
+	if nr.Tags != nil {
+		tags, err := parseTags(*nr.Tags)
+		if err != nil {
+			fields["tags"] = err.Error()
+		}
+		*nr.Tags = tags
+	}
+	if len(fields) > 0 {
+		return validationError{fields: fields}
+	}
+	return nil
+}
+
+func (ah *apiNoteHandler) getUserId(r *http.Request) int64 {
+	return userIdFromContext(r.Context())
+}
+
+func (ah *apiNoteHandler) getNoteId(r *http.Request) (int64, error) {
+	id, err := strconvInt64(r.PathValue("id"))
+	if err != nil || id <= 0 {
+		return 0, ErrInvalidNoteId
+	}
+	return id, nil
+}
+
+func (ah *apiNoteHandler) readRequest(w http.ResponseWriter, r *http.Request, partial bool) (*noteAPIRequest, error) {
+	var req noteAPIRequest
+	if err := readJSON(w, r, &req); err != nil {
+		return nil, err
+	}
+	if err := req.validate(partial); err != nil {
+		return nil, err
+	}
+	return &req, nil
+}
+
+func notesPageLink(opts repositories.NoteListOptions, param string, cursor *repositories.NoteCursor, rel string) string {
+	query := url.Values{
+		"sort": {opts.Sort},
+		"size": {strconv.Itoa(opts.Limit)},
+		param:  {encodeNoteCursor(cursor)},
+	}
+	if opts.Tag != "" {
+		query.Set("tag", opts.Tag)
+	}
+	if opts.Notebook > 0 {
+		query.Set("notebook", strconv.FormatInt(opts.Notebook, 10))
+	}
+	return fmt.Sprintf(`</api/v1/notes?%s>; rel="%s"`, query.Encode(), rel)
+}
+
+// List accepts the same sort, size, after and before parameters as the
+// HTML list. Links to the neighbour pages are sent in the Link header.
+func (ah *apiNoteHandler) List(w http.ResponseWriter, r *http.Request) error {
+	opts := noteListOptionsFromQuery(r.URL.Query())
+	page, err := ah.repo.List(r.Context(), ah.getUserId(r), opts)
+	if err != nil {
+		return err
+	}
+
+	var links []string
+	if page.Prev != nil {
+		links = append(links, notesPageLink(opts, "before", page.Prev, "prev"))
+	}
+	if page.Next != nil {
+		links = append(links, notesPageLink(opts, "after", page.Next, "next"))
+	}
+	if len(links) > 0 {
+		w.Header().Set("Link", strings.Join(links, ", "))
+	}
+
+	resp := newNoteResponseFromNoteList(page.Notes)
+	if resp == nil {
+		resp = []NoteResponse{}
+	}
+	return writeJSON(w, http.StatusOK, resp)
+}
+
+func (ah *apiNoteHandler) Get(w http.ResponseWriter, r *http.Request) error {
+	id, err := ah.getNoteId(r)
+	if err != nil {
+		return err
+	}
+
+	note, err := ah.repo.GetById(r.Context(), ah.getUserId(r), id)
+	if err != nil {
+		return noteError(err)
+	}
+
+	return writeJSON(w, http.StatusOK, newNoteResponseFromNote(note))
+}
+
+func (ah *apiNoteHandler) Create(w http.ResponseWriter, r *http.Request) error {
+	req, err := ah.readRequest(w, r, false)
+	if err != nil {
+		return err
+	}
+
+	color := req.value(req.Color)
+	if color == "" {
+		color = newNoteRequest(nil).Color
+	}
+
+	input := repositories.NoteInput{
+		Title:   req.value(req.Title),
+		Content: req.value(req.Content),
+		Color:   color,
+	}
+	if req.Tags != nil {
+		input.Tags = *req.Tags
+	}
+
+	note, err := ah.repo.Save(r.Context(), ah.getUserId(r), input)
+	if err != nil {
+		return err
+	}
+	note.Tags = input.Tags
+
+	w.Header().Set("Location", fmt.Sprintf("/api/v1/notes/%d", note.Id.Int))
+	return writeJSON(w, http.StatusCreated, newNoteResponseFromNote(note))
+}
+
+// Update handles both PUT (full replacement) and PATCH (partial update).
+// The body must carry the version of the note being changed, and the
+// update fails with 409 if the note was changed since that version.
+func (ah *apiNoteHandler) Update(w http.ResponseWriter, r *http.Request) error {
+	id, err := ah.getNoteId(r)
+	if err != nil {
+		return err
+	}
+
+	req, err := ah.readRequest(w, r, r.Method == http.MethodPatch)
+	if err != nil {
+		return err
+	}
+	if req.Version <= 0 {
+		return validationError{fields: map[string]string{"version": "Versão é obrigatória"}}
+	}
+
+	input := repositories.NoteInput{
+		Id:      id,
+		Version: req.Version,
+		Title:   req.value(req.Title),
+		Content: req.value(req.Content),
+		Color:   req.value(req.Color),
+	}
+	if req.Tags != nil {
+		input.Tags = *req.Tags
+	}
+
+	userId := ah.getUserId(r)
+	if _, err := ah.repo.Save(r.Context(), userId, input); err != nil {
+		return noteError(err)
+	}
+
+	note, err := ah.repo.GetById(r.Context(), userId, id)
+	if err != nil {
+		return noteError(err)
+	}
+
+	return writeJSON(w, http.StatusOK, newNoteResponseFromNote(note))
+}
+
+func (ah *apiNoteHandler) Delete(w http.ResponseWriter, r *http.Request) error {
+	id, err := ah.getNoteId(r)
+	if err != nil {
+		return err
+	}
+
+	if err := ah.repo.Delete(r.Context(), ah.getUserId(r), id); err != nil {
+		return noteError(err)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alexedwards/scs/v2"
+	"github.com/rudsonalves/quicknotes/internal/models"
+	"github.com/rudsonalves/quicknotes/internal/render"
+	"github.com/rudsonalves/quicknotes/internal/repositories"
+	"github.com/rudsonalves/quicknotes/utils"
+)
+
+// apiTokenPrefix identifies quicknotes personal access tokens.
+const apiTokenPrefix = "qn_"
+
+type apiTokenHandler struct {
+	repo    repositories.APITokenRepository
+	session *scs.SessionManager
+	render  *render.RenderTemplate
+}
+
+func NewAPITokenHandler(
+	session *scs.SessionManager,
+	tokenRepo repositories.APITokenRepository,
+	render *render.RenderTemplate) *apiTokenHandler {
+	return &apiTokenHandler{
+		repo:    tokenRepo,
+		session: session,
+		render:  render}
+}
+
+func (th *apiTokenHandler) getUserIdFromSession(r *http.Request) int64 {
+	return th.session.GetInt64(r.Context(), "userId")
+}
+
+func (th *apiTokenHandler) TokenList(w http.ResponseWriter, r *http.Request) error {
+	tokens, err := th.repo.List(r.Context(), th.getUserIdFromSession(r))
+	if err != nil {
+		return err
+	}
+
+	return th.render.RenderPage(w, r, http.StatusOK, "api-tokens.html", newAPITokenRequest(tokens))
+}
+
+func (th *apiTokenHandler) TokenCreate(w http.ResponseWriter, r *http.Request) error {
+	if err := r.ParseForm(); err != nil {
+		return err
+	}
+	userId := th.getUserIdFromSession(r)
+
+	tokens, err := th.repo.List(r.Context(), userId)
+	if err != nil {
+		return err
+	}
+
+	data := newAPITokenRequest(tokens)
+	data.Name = strings.TrimSpace(r.PostForm.Get("name"))
+	data.Scopes = r.PostForm["scopes"]
+	data.ExpiresInDays, _ = strconv.Atoi(r.PostForm.Get("expires"))
+
+	if data.Name == "" {
+		data.AddFieldError("name", "Nome é obrigatório")
+	}
+	if len(data.Scopes) == 0 {
+		data.AddFieldError("scopes", "Selecione ao menos uma permissão")
+	}
+	for _, scope := range data.Scopes {
+		if !slices.Contains(models.APIScopes, scope) {
+			data.AddFieldError("scopes", "Permissão inválida")
+		}
+	}
+	if data.ExpiresInDays < 0 {
+		data.AddFieldError("expires", "Validade inválida")
+	}
+
+	if !data.Valid() {
+		return th.render.RenderPage(w, r, http.StatusUnprocessableEntity, "api-tokens.html", data)
+	}
+
+	var expiresAt *time.Time
+	if data.ExpiresInDays > 0 {
+		expires := time.Now().AddDate(0, 0, data.ExpiresInDays)
+		expiresAt = &expires
+	}
+
+	rawToken := apiTokenPrefix + utils.GenerateTokenKey()
+	if _, err := th.repo.Create(r.Context(), userId, data.Name, utils.HashToken(rawToken), data.Scopes, expiresAt); err != nil {
+		return err
+	}
+
+	tokens, err = th.repo.List(r.Context(), userId)
+	if err != nil {
+		return err
+	}
+
+	// the raw token is shown only once; only its hash is stored
+	resp := newAPITokenRequest(tokens)
+	resp.NewToken = rawToken
+	return th.render.RenderPage(w, r, http.StatusCreated, "api-tokens.html", resp)
+}
+
+func (th *apiTokenHandler) TokenRevoke(w http.ResponseWriter, r *http.Request) error {
+	id, err := strconvInt64(r.PathValue("id"))
+	if err != nil {
+		return err
+	}
+
+	if err := th.repo.Revoke(r.Context(), th.getUserIdFromSession(r), id); err != nil {
+		if errors.Is(err, repositories.ErrAPITokenNotFound) {
+			return ErrNotFound
+		}
+		return err
+	}
+
+	return nil
+}
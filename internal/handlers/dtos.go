@@ -2,16 +2,95 @@ package handlers
 
 import (
 	"fmt"
+	"html"
+	"html/template"
+	"strings"
+	"time"
 
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/rudsonalves/quicknotes/internal/diff"
 	"github.com/rudsonalves/quicknotes/internal/models"
+	"github.com/rudsonalves/quicknotes/internal/repositories"
 	"github.com/rudsonalves/quicknotes/internal/validations"
 )
 
 type NoteResponse struct {
-	Id      int64
-	Title   string
-	Content string
-	Color   string
+	Id        int64         `json:"id"`
+	Title     string        `json:"title"`
+	Content   string        `json:"content"`
+	Color     string        `json:"color"`
+	Version   int32         `json:"version"`
+	Pinned    bool          `json:"pinned"`
+	Archived  bool          `json:"archived"`
+	Tags      []string      `json:"tags"`
+	Snippet   template.HTML `json:"-"`
+	RemindAt  string        `json:"-"`
+	DeletedAt string        `json:"-"`
+	// IsChecklist notes show ItemsDone of ItemsTotal items on the cards.
+	IsChecklist bool `json:"-"`
+	ItemsDone   int  `json:"-"`
+	ItemsTotal  int  `json:"-"`
+}
+
+type NoteSortOption struct {
+	Value string
+	Label string
+}
+
+var noteSortOptions = []NoteSortOption{
+	{Value: repositories.NoteSortUpdated, Label: "Última alteração"},
+	{Value: repositories.NoteSortCreated, Label: "Data de criação"},
+	{Value: repositories.NoteSortTitle, Label: "Título"},
+	{Value: repositories.NoteSortColor, Label: "Cor"},
+}
+
+var notePageSizes = []int{10, 20, 50, 100}
+
+type TagResponse struct {
+	Name  string
+	Count int
+}
+
+func newTagResponseList(tags []models.Tag) (resp []TagResponse) {
+	for _, tag := range tags {
+		resp = append(resp, TagResponse{Name: tag.Name.String, Count: tag.NoteCount})
+	}
+	return
+}
+
+type NoteListResponse struct {
+	Archived    bool
+	BasePath    string
+	Query       string
+	Tag         string
+	Notebook    *NotebookResponse
+	TagCloud    []TagResponse
+	Notes       []NoteResponse
+	Sort        string
+	Size        int
+	SortOptions []NoteSortOption
+	PageSizes   []int
+	NextCursor  string
+	PrevCursor  string
+	Shared      []SharedNoteResponse
+	Reminders   []ReminderResponse
+}
+
+func newNoteListResponse(query string, opts repositories.NoteListOptions) NoteListResponse {
+	basePath := "/note"
+	if opts.Archived {
+		basePath = "/note/archive"
+	}
+	return NoteListResponse{
+		Archived:    opts.Archived,
+		BasePath:    basePath,
+		Query:       query,
+		Tag:         opts.Tag,
+		Sort:        opts.Sort,
+		Size:        opts.Limit,
+		SortOptions: noteSortOptions,
+		PageSizes:   notePageSizes,
+	}
 }
 
 func newNoteResponseFromNote(note *models.Note) (resp NoteResponse) {
@@ -20,6 +99,17 @@ func newNoteResponseFromNote(note *models.Note) (resp NoteResponse) {
 	resp.Title = note.Title.String
 	resp.Content = note.Content.String
 	resp.Color = note.Color.String
+	resp.Version = note.Version.Int32
+	resp.Pinned = note.Pinned.Bool
+	resp.Archived = note.Archived.Bool
+	resp.Tags = note.Tags
+	resp.IsChecklist = note.IsChecklist()
+	resp.ItemsDone = note.ItemsDone
+	resp.ItemsTotal = note.ItemsTotal
+	resp.DeletedAt = formatTimestamp(note.DeletedAt)
+	if resp.Tags == nil {
+		resp.Tags = []string{}
+	}
 	return
 }
 
@@ -29,6 +119,29 @@ type NoteRequest struct {
 	Content string
 	Color   string
 	Colors  []string
+	Tags    string
+	Version int32
+	// Kind is either a text note or a checklist, whose items are written
+	// one per line in Content.
+	Kind string
+	// Notebooks lists where the note may be moved to. It is empty for
+	// editors of a shared note, who cannot move it.
+	NotebookId int64
+	Notebooks  []NotebookResponse
+	// Templates the new note may start from, TemplateId being the one
+	// used to fill the form.
+	TemplateId int64
+	Templates  []NoteTemplateResponse
+	// IsOwner tells whether the user owns the note, as only the owner is
+	// reminded of it.
+	IsOwner bool
+	// RemindAt is the reminder in the datetime-local input format.
+	RemindAt string
+	// Attachments of the note being edited, managed by a separate form.
+	Attachments []AttachmentResponse
+	// Stored holds the note saved by a concurrent update, shown next to
+	// the submitted values so the user can merge them.
+	Stored *NoteResponse
 	validations.FormValidator
 }
 
@@ -44,15 +157,61 @@ func newUserRequest(email, password string) (req UserRequest) {
 	return
 }
 
-func newNoteRequest(note *models.Note) (req NoteRequest) {
+// TwoFactorRequest holds the two-factor authentication page. While it is
+// disabled, the secret being enrolled is shown as a QR code and as text.
+type TwoFactorRequest struct {
+	Enabled         bool
+	Secret          string
+	ProvisioningURI template.URL
+	QRCode          template.HTML
+	RecoveryCodes   []string
+	RemainingCodes  int
+	validations.FormValidator
+}
+
+// AccountRequest holds the account page, with the profile, the
+// preferences and the forms to change the email and the password.
+type AccountRequest struct {
+	Email        string
+	PendingEmail string
+	DisplayName  string
+	Timezone     string
+	Timezones    []string
+	validations.FormValidator
+}
+
+func newAccountRequest(user *models.User) AccountRequest {
+	return AccountRequest{
+		Email:        user.Email.String,
+		PendingEmail: user.PendingEmail.String,
+		DisplayName:  user.DisplayName.String,
+		Timezone:     user.Timezone.String,
+		Timezones:    models.Timezones,
+	}
+}
+
+func noteColors() (colors []string) {
 	for index := 1; index <= 9; index++ {
-		req.Colors = append(req.Colors, fmt.Sprintf("color%d", index))
+		colors = append(colors, fmt.Sprintf("color%d", index))
 	}
+	return
+}
+
+func newNoteRequest(note *models.Note) (req NoteRequest) {
+	req.Colors = noteColors()
+	req.IsOwner = true
+	req.Kind = models.NoteKindText
 	if note != nil {
+		if note.IsChecklist() {
+			req.Kind = models.NoteKindChecklist
+		}
 		req.Id = note.Id.Int.Int64()
 		req.Title = note.Title.String
 		req.Color = note.Color.String
 		req.Content = note.Content.String
+		req.Tags = strings.Join(note.Tags, ", ")
+		req.Version = note.Version.Int32
+		req.NotebookId = optionalId(note.NotebookId)
 	} else {
 		req.Color = req.Colors[2]
 	}
@@ -66,3 +225,463 @@ func newNoteResponseFromNoteList(notes []models.Note) (resp []NoteResponse) {
 
 	return
 }
+
+type NoteShareRoleOption struct {
+	Value string
+	Label string
+}
+
+var noteShareRoleOptions = []NoteShareRoleOption{
+	{Value: models.NoteRoleViewer, Label: "Leitor"},
+	{Value: models.NoteRoleEditor, Label: "Editor"},
+}
+
+func noteRoleLabel(role string) string {
+	for _, option := range noteShareRoleOptions {
+		if option.Value == role {
+			return option.Label
+		}
+	}
+	return "Dono"
+}
+
+type NoteShareResponse struct {
+	Id        int64
+	Email     string
+	Role      string
+	RoleLabel string
+}
+
+func newNoteShareResponseList(shares []models.NoteShare) (resp []NoteShareResponse) {
+	for _, share := range shares {
+		resp = append(resp, NoteShareResponse{
+			Id:        share.Id.Int.Int64(),
+			Email:     share.Email.String,
+			Role:      share.Role.String,
+			RoleLabel: noteRoleLabel(share.Role.String),
+		})
+	}
+	return
+}
+
+// ReminderResponse is an upcoming reminder listed on the home page.
+// Overdue reminders are about to be sent.
+type ReminderResponse struct {
+	NoteId   int64
+	Title    string
+	Color    string
+	RemindAt string
+	Overdue  bool
+}
+
+func newReminderResponseList(notes []models.Note, loc *time.Location) (resp []ReminderResponse) {
+	now := time.Now()
+	for _, note := range notes {
+		resp = append(resp, ReminderResponse{
+			NoteId:   note.Id.Int.Int64(),
+			Title:    note.Title.String,
+			Color:    note.Color.String,
+			RemindAt: formatTimestamptz(note.RemindAt, loc),
+			Overdue:  note.RemindAt.Time.Before(now),
+		})
+	}
+	return
+}
+
+type SharedNoteResponse struct {
+	NoteResponse
+	RoleLabel  string
+	OwnerEmail string
+}
+
+func newSharedNoteResponseList(notes []models.SharedNote) (resp []SharedNoteResponse) {
+	for _, note := range notes {
+		resp = append(resp, SharedNoteResponse{
+			NoteResponse: newNoteResponseFromNote(&note.Note),
+			RoleLabel:    noteRoleLabel(note.Role),
+			OwnerEmail:   note.OwnerEmail,
+		})
+	}
+	return
+}
+
+// NoteViewResponse is the note page. Shares and the share form are only
+// shown to the owner of the note.
+type NoteViewResponse struct {
+	NoteResponse
+	Role       string
+	OwnerEmail string
+	Shares     []NoteShareResponse
+	ShareRoles []NoteShareRoleOption
+	ShareEmail string
+	ShareRole  string
+	Links      []NoteLinkResponse
+	// Attachments are listed to everyone who can read the note.
+	Attachments []AttachmentResponse
+	// Items of a checklist note, shown instead of its content.
+	Items []ChecklistItemResponse
+	// LinkExpiresInDays is the validity chosen for a new public link,
+	// zero meaning it never expires.
+	LinkExpiresInDays int
+	validations.FormValidator
+}
+
+// newNoteViewResponse shows the reminder in the time zone loc of the
+// user.
+func newNoteViewResponse(note *models.SharedNote, loc *time.Location) NoteViewResponse {
+	resp := newNoteResponseFromNote(&note.Note)
+	resp.RemindAt = formatTimestamptz(note.RemindAt, loc)
+	return NoteViewResponse{
+		NoteResponse:      resp,
+		Role:              note.Role,
+		OwnerEmail:        note.OwnerEmail,
+		ShareRoles:        noteShareRoleOptions,
+		ShareRole:         models.NoteRoleViewer,
+		LinkExpiresInDays: 7,
+	}
+}
+
+func (v NoteViewResponse) IsOwner() bool {
+	return v.Role == models.NoteRoleOwner
+}
+
+func (v NoteViewResponse) CanEdit() bool {
+	return v.Role == models.NoteRoleOwner || v.Role == models.NoteRoleEditor
+}
+
+type NoteLinkResponse struct {
+	Id          int64
+	Path        string
+	HasPassword bool
+	ExpiresAt   string
+	CreatedAt   string
+}
+
+func newNoteLinkResponseList(links []models.NoteLink) (resp []NoteLinkResponse) {
+	for _, link := range links {
+		resp = append(resp, NoteLinkResponse{
+			Id:          link.Id.Int.Int64(),
+			Path:        "/s/" + link.Token.String,
+			HasPassword: link.HasPassword(),
+			ExpiresAt:   formatTimestamp(link.ExpiresAt),
+			CreatedAt:   formatTimestamp(link.CreatedAt),
+		})
+	}
+	return
+}
+
+type ChecklistItemResponse struct {
+	Id      int64  `json:"id"`
+	Text    string `json:"text"`
+	Checked bool   `json:"checked"`
+}
+
+func newChecklistItemResponse(item *models.ChecklistItem) ChecklistItemResponse {
+	return ChecklistItemResponse{
+		Id:      item.Id.Int.Int64(),
+		Text:    item.Text.String,
+		Checked: item.Checked.Bool,
+	}
+}
+
+func newChecklistItemResponseList(items []models.ChecklistItem) (resp []ChecklistItemResponse) {
+	for _, item := range items {
+		resp = append(resp, newChecklistItemResponse(&item))
+	}
+	return
+}
+
+// AttachmentResponse is a file attached to a note. Path serves its
+// contents, inline for images so they can be previewed.
+type AttachmentResponse struct {
+	Id          int64
+	FileName    string
+	ContentType string
+	Size        string
+	IsImage     bool
+	Path        string
+	CreatedAt   string
+}
+
+func newAttachmentResponseList(attachments []models.Attachment) (resp []AttachmentResponse) {
+	for _, attachment := range attachments {
+		resp = append(resp, AttachmentResponse{
+			Id:          attachment.Id.Int.Int64(),
+			FileName:    attachment.FileName.String,
+			ContentType: attachment.ContentType.String,
+			Size:        formatFileSize(attachment.Size.Int64),
+			IsImage:     attachment.IsImage(),
+			Path:        fmt.Sprintf("/note/%d/attachments/%d", attachment.NoteId.Int, attachment.Id.Int),
+			CreatedAt:   formatTimestamp(attachment.CreatedAt),
+		})
+	}
+	return
+}
+
+// formatFileSize shows a size in bytes, KB or MB.
+func formatFileSize(size int64) string {
+	switch {
+	case size < 1<<10:
+		return fmt.Sprintf("%d bytes", size)
+	case size < 1<<20:
+		return fmt.Sprintf("%.1f KB", float64(size)/(1<<10))
+	default:
+		return fmt.Sprintf("%.1f MB", float64(size)/(1<<20))
+	}
+}
+
+// PublicNoteResponse is the page of a public link. Note is only filled
+// once the link password, if any, was given.
+type PublicNoteResponse struct {
+	Token string
+	Note  *NoteResponse
+	validations.FormValidator
+}
+
+func newPublicNoteResponse(token string) PublicNoteResponse {
+	return PublicNoteResponse{Token: token}
+}
+
+type NoteTemplateResponse struct {
+	Id      int64
+	Title   string
+	Content string
+	Color   string
+}
+
+func newNoteTemplateResponseList(templates []models.NoteTemplate) (resp []NoteTemplateResponse) {
+	for _, tmpl := range templates {
+		resp = append(resp, NoteTemplateResponse{
+			Id:      tmpl.Id.Int.Int64(),
+			Title:   tmpl.Title.String,
+			Content: tmpl.Content.String,
+			Color:   tmpl.Color.String,
+		})
+	}
+	return
+}
+
+// NoteTemplateRequest backs the template pages. Templates lists every
+// template of the user on the listing page.
+type NoteTemplateRequest struct {
+	Id        int64
+	Title     string
+	Content   string
+	Color     string
+	Colors    []string
+	Templates []NoteTemplateResponse
+	validations.FormValidator
+}
+
+func newNoteTemplateRequest(templates []models.NoteTemplate) NoteTemplateRequest {
+	colors := noteColors()
+	return NoteTemplateRequest{
+		Color:     colors[2],
+		Colors:    colors,
+		Templates: newNoteTemplateResponseList(templates),
+	}
+}
+
+// NotebookResponse is a notebook of a listing. Label is the name indented
+// by its depth, used by the select boxes.
+type NotebookResponse struct {
+	Id        int64
+	ParentId  int64
+	Name      string
+	Label     string
+	Depth     int
+	NoteCount int
+}
+
+func newNotebookResponse(notebook *models.Notebook) NotebookResponse {
+	return NotebookResponse{
+		Id:        notebook.Id.Int.Int64(),
+		ParentId:  optionalId(notebook.ParentId),
+		Name:      notebook.Name.String,
+		Label:     strings.Repeat("— ", notebook.Depth) + notebook.Name.String,
+		Depth:     notebook.Depth,
+		NoteCount: notebook.NoteCount,
+	}
+}
+
+func newNotebookResponseList(notebooks []models.Notebook) (resp []NotebookResponse) {
+	for _, notebook := range notebooks {
+		resp = append(resp, newNotebookResponse(&notebook))
+	}
+	return
+}
+
+// NotebookRequest backs the notebook pages. Notebooks lists every notebook
+// of the user, in tree order, to be shown and chosen as parent.
+type NotebookRequest struct {
+	Id        int64
+	Name      string
+	ParentId  int64
+	Notebooks []NotebookResponse
+	validations.FormValidator
+}
+
+func newNotebookRequest(notebooks []models.Notebook) NotebookRequest {
+	return NotebookRequest{Notebooks: newNotebookResponseList(notebooks)}
+}
+
+type ImportErrorResponse struct {
+	File    string
+	Message string
+}
+
+// NoteImportResponse is the import form and, once Done, the report of the
+// imported files and of the ones that failed.
+type NoteImportResponse struct {
+	Done     bool
+	Imported []string
+	Errors   []ImportErrorResponse
+	validations.FormValidator
+}
+
+func (resp *NoteImportResponse) addFileError(file string, err error) {
+	resp.Errors = append(resp.Errors, ImportErrorResponse{File: file, Message: err.Error()})
+}
+
+// highlightSnippet escapes a ts_headline snippet, keeping only the <mark>
+// tags added by the database around the matched terms.
+func highlightSnippet(snippet string) template.HTML {
+	escaped := html.EscapeString(snippet)
+	escaped = strings.ReplaceAll(escaped, "&lt;mark&gt;", "<mark>")
+	escaped = strings.ReplaceAll(escaped, "&lt;/mark&gt;", "</mark>")
+	return template.HTML(escaped)
+}
+
+func newNoteResponseFromSearchList(notes []models.NoteSearchResult) (resp []NoteResponse) {
+	for _, note := range notes {
+		item := newNoteResponseFromNote(&note.Note)
+		item.Snippet = highlightSnippet(note.Snippet.String)
+		resp = append(resp, item)
+	}
+
+	return
+}
+
+const dateTimeLayout = "02/01/2006 15:04"
+
+// reminderInputLayout is the format of datetime-local inputs.
+const reminderInputLayout = "2006-01-02T15:04"
+
+func formatTimestamp(ts pgtype.Timestamp) string {
+	if !ts.Valid {
+		return ""
+	}
+	return ts.Time.Format(dateTimeLayout)
+}
+
+// formatTimestamptz shows a timestamp with time zone in the time zone loc.
+func formatTimestamptz(ts pgtype.Timestamptz, loc *time.Location) string {
+	if !ts.Valid {
+		return ""
+	}
+	return ts.Time.In(loc).Format(dateTimeLayout)
+}
+
+// optionalId returns the id held by a nullable column, zero when null.
+func optionalId(id pgtype.Numeric) int64 {
+	if !id.Valid {
+		return 0
+	}
+	return id.Int.Int64()
+}
+
+type APITokenResponse struct {
+	Id         int64
+	Name       string
+	Scopes     []string
+	ExpiresAt  string
+	LastUsedAt string
+	CreatedAt  string
+	Expired    bool
+}
+
+func newAPITokenResponseList(tokens []models.APIToken) (resp []APITokenResponse) {
+	for _, token := range tokens {
+		resp = append(resp, APITokenResponse{
+			Id:         token.Id.Int.Int64(),
+			Name:       token.Name.String,
+			Scopes:     token.Scopes,
+			ExpiresAt:  formatTimestamp(token.ExpiresAt),
+			LastUsedAt: formatTimestamp(token.LastUsedAt),
+			CreatedAt:  formatTimestamp(token.CreatedAt),
+			Expired:    token.Expired,
+		})
+	}
+	return
+}
+
+type APITokenRequest struct {
+	Name            string
+	Scopes          []string
+	ExpiresInDays   int
+	AvailableScopes []string
+	NewToken        string
+	Tokens          []APITokenResponse
+	validations.FormValidator
+}
+
+func newAPITokenRequest(tokens []models.APIToken) (req APITokenRequest) {
+	req.AvailableScopes = models.APIScopes
+	req.Scopes = []string{models.ScopeNotesRead}
+	req.ExpiresInDays = 30
+	req.Tokens = newAPITokenResponseList(tokens)
+	return
+}
+
+type NoteRevisionResponse struct {
+	Id        int64
+	Title     string
+	Color     string
+	CreatedAt string
+	Current   bool
+}
+
+func newNoteRevisionResponse(revision *models.NoteRevision) NoteRevisionResponse {
+	return NoteRevisionResponse{
+		Id:        revision.Id.Int.Int64(),
+		Title:     revision.Title.String,
+		Color:     revision.Color.String,
+		CreatedAt: formatTimestamp(revision.CreatedAt),
+	}
+}
+
+type NoteHistoryResponse struct {
+	Note      NoteResponse
+	Revisions []NoteRevisionResponse
+}
+
+func newNoteHistoryResponse(note *models.Note, revisions []models.NoteRevision) (resp NoteHistoryResponse) {
+	resp.Note = newNoteResponseFromNote(note)
+	for index, revision := range revisions {
+		item := newNoteRevisionResponse(&revision)
+		// revisions are listed newest first, the first one is the current state
+		item.Current = index == 0
+		resp.Revisions = append(resp.Revisions, item)
+	}
+	return
+}
+
+type NoteDiffResponse struct {
+	Note      NoteResponse
+	From      NoteRevisionResponse
+	To        NoteRevisionResponse
+	TitleDiff []diff.Line
+	ColorDiff bool
+	Lines     []diff.Line
+}
+
+func newNoteDiffResponse(note *models.Note, from, to *models.NoteRevision) NoteDiffResponse {
+	return NoteDiffResponse{
+		Note:      newNoteResponseFromNote(note),
+		From:      newNoteRevisionResponse(from),
+		To:        newNoteRevisionResponse(to),
+		TitleDiff: diff.Lines(from.Title.String, to.Title.String),
+		ColorDiff: from.Color.String != to.Color.String,
+		Lines:     diff.Lines(from.Content.String, to.Content.String),
+	}
+}
@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	appError "github.com/rudsonalves/quicknotes/internal/app_error"
+)
+
+const maxJSONBodySize = 1 << 20
+
+var ErrInvalidJSON = appError.WithStatus(errors.New("corpo da requisição inválido"), http.StatusBadRequest)
+
+type errorResponse struct {
+	Error  string            `json:"error"`
+	Fields map[string]string `json:"fields,omitempty"`
+}
+
+// validationError carries field errors of an API request body.
+type validationError struct {
+	fields map[string]string
+}
+
+func (ve validationError) Error() string {
+	return "dados da requisição inválidos"
+}
+
+func writeJSON(w http.ResponseWriter, status int, data any) error {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	if data == nil {
+		return nil
+	}
+	return json.NewEncoder(w).Encode(data)
+}
+
+func writeJSONError(w http.ResponseWriter, status int, msg string) {
+	if err := writeJSON(w, status, errorResponse{Error: msg}); err != nil {
+		slog.Error(err.Error())
+	}
+}
+
+func readJSON(w http.ResponseWriter, r *http.Request, dst any) error {
+	r.Body = http.MaxBytesReader(w, r.Body, maxJSONBodySize)
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(dst); err != nil {
+		slog.Warn(err.Error())
+		return ErrInvalidJSON
+	}
+	return nil
+}
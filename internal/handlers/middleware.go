@@ -1,25 +1,45 @@
 package handlers
 
 import (
+	"context"
 	"errors"
 	"log/slog"
+	"net"
 	"net/http"
+	"net/netip"
+	"strings"
 
 	"github.com/alexedwards/scs/v2"
+	"github.com/gorilla/csrf"
 	appError "github.com/rudsonalves/quicknotes/internal/app_error"
 	"github.com/rudsonalves/quicknotes/internal/render"
 	"github.com/rudsonalves/quicknotes/internal/repositories"
+	"github.com/rudsonalves/quicknotes/utils"
 )
 
 var ErrNotFound = appError.WithStatus(errors.New("página não encontrada"), http.StatusNotFound)
 var ErrInternal = appError.WithStatus(errors.New("ocorreu um erro ao executar essa página"), http.StatusInternalServerError)
+var ErrUnauthorized = appError.WithStatus(errors.New("autenticação necessária"), http.StatusUnauthorized)
+var ErrInvalidAPIToken = appError.WithStatus(errors.New("token inválido ou expirado"), http.StatusUnauthorized)
+var ErrForbiddenScope = appError.WithStatus(errors.New("token não possui permissão para esta operação"), http.StatusForbidden)
+
+type contextKey string
+
+const userIdContextKey contextKey = "userId"
+
+// userIdFromContext returns the user authenticated by RequireAPIAuth.
+func userIdFromContext(ctx context.Context) int64 {
+	userId, _ := ctx.Value(userIdContextKey).(int64)
+	return userId
+}
 
 type authMiddleware struct {
-	session *scs.SessionManager
+	session   *scs.SessionManager
+	tokenRepo repositories.APITokenRepository
 }
 
-func NewAuthMiddleware(session *scs.SessionManager) *authMiddleware {
-	return &authMiddleware{session: session}
+func NewAuthMiddleware(session *scs.SessionManager, tokenRepo repositories.APITokenRepository) *authMiddleware {
+	return &authMiddleware{session: session, tokenRepo: tokenRepo}
 }
 
 func (au *authMiddleware) RequireAuth(next http.Handler) http.Handler {
@@ -34,6 +54,124 @@ func (au *authMiddleware) RequireAuth(next http.Handler) http.Handler {
 	})
 }
 
+// RequireAPIAuth authenticates API requests either by a personal access
+// token sent as "Authorization: Bearer <token>", which must hold the given
+// scope, or by the session cookie. Unauthenticated requests get a JSON 401
+// instead of a redirect to the signin page.
+func (au *authMiddleware) RequireAPIAuth(scope string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var userId int64
+		if header := r.Header.Get("Authorization"); header != "" {
+			rawToken, ok := strings.CutPrefix(header, "Bearer ")
+			if !ok || strings.TrimSpace(rawToken) == "" {
+				writeJSONError(w, http.StatusUnauthorized, ErrInvalidAPIToken.Error())
+				return
+			}
+
+			token, err := au.tokenRepo.FindValidByHash(r.Context(), utils.HashToken(strings.TrimSpace(rawToken)))
+			if err != nil {
+				if errors.Is(err, repositories.ErrAPITokenNotFound) {
+					slog.Warn("token de API inválido")
+					writeJSONError(w, http.StatusUnauthorized, ErrInvalidAPIToken.Error())
+					return
+				}
+				slog.Error(err.Error())
+				writeJSONError(w, http.StatusInternalServerError, ErrInternal.Error())
+				return
+			}
+
+			if !token.HasScope(scope) {
+				writeJSONError(w, http.StatusForbidden, ErrForbiddenScope.Error())
+				return
+			}
+
+			if err := au.tokenRepo.Touch(r.Context(), token.Id.Int.Int64()); err != nil {
+				slog.Error(err.Error())
+			}
+			userId = token.UserId.Int.Int64()
+		} else {
+			userId = au.session.GetInt64(r.Context(), "userId")
+		}
+
+		if userId == 0 {
+			writeJSONError(w, http.StatusUnauthorized, ErrUnauthorized.Error())
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), userIdContextKey, userId)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// SkipCSRFForBearer disables the CSRF check for API requests carrying a
+// bearer token. Browsers never attach the Authorization header on their
+// own, so these requests cannot be forged cross-site. It must wrap the
+// csrf.Protect middleware.
+func SkipCSRFForBearer(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/api/") &&
+			strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") {
+			r = csrf.UnsafeSkipCheck(r)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// MaxRequestBodySize is the largest request body accepted, which bounds
+// the uploads of the note import.
+const MaxRequestBodySize = 32 << 20
+
+// LimitRequestBody caps the size of every request body. It must wrap the
+// csrf.Protect middleware, which reads form bodies to find the token.
+func LimitRequestBody(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, MaxRequestBodySize)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ForwardedFor sets the RemoteAddr of requests relayed by one of the
+// trusted proxies to the client address in X-Forwarded-For, so the
+// limiters count clients instead of the proxy. The header is read from the
+// right, skipping the trusted proxies, since only the entries they
+// appended can be believed. Requests from any other peer keep their
+// RemoteAddr.
+func ForwardedFor(trusted []netip.Prefix) func(http.Handler) http.Handler {
+	isTrusted := func(addr netip.Addr) bool {
+		addr = addr.Unmap()
+		for _, prefix := range trusted {
+			if prefix.Contains(addr) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			peer, err := netip.ParseAddrPort(r.RemoteAddr)
+			if err != nil || !isTrusted(peer.Addr()) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+			for i := len(hops) - 1; i >= 0; i-- {
+				addr, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+				if err != nil {
+					break
+				}
+				if !isTrusted(addr) {
+					r = r.Clone(r.Context())
+					r.RemoteAddr = net.JoinHostPort(addr.Unmap().String(), "0")
+					break
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
 type errorHandlerMiddleware struct {
 	render *render.RenderTemplate
 }
@@ -46,13 +184,17 @@ func (em *errorHandlerMiddleware) HandleError(next func(w http.ResponseWriter, r
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if err := next(w, r); err != nil {
 			var statusError appError.StatusError
-			var repoError *repositories.RepositoriesError
+			var repoError repositories.RepositoriesError
 			if errors.As(err, &statusError) {
 				if statusError.StatusCode() == http.StatusNotFound {
 					// render a default err page
 					em.render.RenderPage(w, r, http.StatusNotFound, "404.html", nil)
 					return
 				}
+				if statusError.StatusCode() < http.StatusInternalServerError {
+					em.render.RenderPage(w, r, statusError.StatusCode(), "generic-error.html", statusError.Error())
+					return
+				}
 			}
 
 			// repositories errors
@@ -68,3 +210,40 @@ func (em *errorHandlerMiddleware) HandleError(next func(w http.ResponseWriter, r
 		}
 	})
 }
+
+// HandleAPIError writes errors returned by API handlers as JSON bodies.
+func (em *errorHandlerMiddleware) HandleAPIError(next func(w http.ResponseWriter, r *http.Request) error) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := next(w, r); err != nil {
+			var statusError appError.StatusError
+			var repoError repositories.RepositoriesError
+			var validError validationError
+			if errors.As(err, &validError) {
+				writeJSON(w, http.StatusUnprocessableEntity, errorResponse{
+					Error:  validError.Error(),
+					Fields: validError.fields,
+				})
+				return
+			}
+
+			if errors.As(err, &statusError) {
+				if statusError.StatusCode() >= http.StatusInternalServerError {
+					slog.Error(err.Error())
+				}
+				writeJSONError(w, statusError.StatusCode(), statusError.Error())
+				return
+			}
+
+			// repositories errors
+			if errors.As(err, &repoError) {
+				slog.Error(err.Error())
+				writeJSONError(w, http.StatusInternalServerError, "aconteceu um erro ao executar essa operação.")
+				return
+			}
+
+			// others generic errors
+			slog.Error(err.Error())
+			writeJSONError(w, http.StatusInternalServerError, ErrInternal.Error())
+		}
+	})
+}
@@ -0,0 +1,230 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/rudsonalves/quicknotes/internal/models"
+	"github.com/rudsonalves/quicknotes/internal/storage"
+	"github.com/rudsonalves/quicknotes/utils"
+)
+
+const (
+	maxAttachmentSize     = 10 << 20
+	maxAttachmentMemory   = 1 << 20
+	maxAttachmentsPerNote = 20
+	maxAttachmentName     = 255
+)
+
+// attachmentTypes are the content types accepted for uploads. The type is
+// sniffed from the file contents, the one sent by the browser is ignored.
+var attachmentTypes = map[string]bool{
+	"image/png":       true,
+	"image/jpeg":      true,
+	"image/gif":       true,
+	"image/webp":      true,
+	"application/pdf": true,
+}
+
+// attachmentName keeps the base name of an uploaded file, without control
+// characters and limited in length.
+func attachmentName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f {
+			return -1
+		}
+		return r
+	}, filepath.Base(strings.ReplaceAll(name, `\`, "/")))
+	name = strings.TrimSpace(name)
+	if name == "" || name == "." || name == "/" {
+		return "anexo"
+	}
+	for utf8.RuneCountInString(name) > maxAttachmentName {
+		_, size := utf8.DecodeLastRuneInString(name)
+		name = name[:len(name)-size]
+	}
+	return name
+}
+
+// loadAttachments fills the attachments of the note being edited.
+func (nh *noteHandler) loadAttachments(r *http.Request, ownerId int64, data *NoteRequest) error {
+	attachments, err := nh.attachmentRepo.List(r.Context(), ownerId, data.Id)
+	if err != nil {
+		return err
+	}
+	data.Attachments = newAttachmentResponseList(attachments)
+	return nil
+}
+
+// noteForChange loads a note the user may edit, either as its owner or as
+// an editor of a shared note.
+func (nh *noteHandler) noteForChange(r *http.Request) (*models.SharedNote, error) {
+	id, err := strconvInt64(r.PathValue("id"))
+	if err != nil {
+		return nil, err
+	}
+	note, err := nh.noteWithRole(r, id)
+	if err != nil {
+		return nil, err
+	}
+	if note.Role == models.NoteRoleViewer {
+		return nil, ErrNoteForbidden
+	}
+	return note, nil
+}
+
+// NoteAttachmentUpload stores a file sent by the upload form of the edit
+// page. Files that are too large or of an unsupported type show the form
+// again with the error.
+func (nh *noteHandler) NoteAttachmentUpload(w http.ResponseWriter, r *http.Request) error {
+	note, err := nh.noteForChange(r)
+	if err != nil {
+		return err
+	}
+	noteId := note.Id.Int.Int64()
+	ownerId := note.UserId.Int.Int64()
+
+	data, err := nh.newNoteEdit(r, note)
+	if err != nil {
+		return err
+	}
+
+	if err := r.ParseMultipartForm(maxAttachmentMemory); err != nil {
+		data.AddFieldError("attachment", "Não foi possível ler o arquivo enviado")
+		return nh.render.RenderPage(w, r, http.StatusUnprocessableEntity, "note-edit.html", data)
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		data.AddFieldError("attachment", "Selecione um arquivo")
+		return nh.render.RenderPage(w, r, http.StatusUnprocessableEntity, "note-edit.html", data)
+	}
+	defer file.Close()
+
+	var contentType string
+	if header.Size > maxAttachmentSize {
+		data.AddFieldError("attachment", fmt.Sprintf("O arquivo deve ter até %s", formatFileSize(maxAttachmentSize)))
+	} else if len(data.Attachments) >= maxAttachmentsPerNote {
+		data.AddFieldError("attachment", fmt.Sprintf("Uma anotação pode ter até %d anexos", maxAttachmentsPerNote))
+	} else {
+		sniff := make([]byte, 512)
+		n, err := io.ReadFull(file, sniff)
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+			return err
+		}
+		contentType, _, _ = mime.ParseMediaType(http.DetectContentType(sniff[:n]))
+		if !attachmentTypes[contentType] {
+			data.AddFieldError("attachment", "Envie imagens PNG, JPEG, GIF, WebP ou documentos PDF")
+		} else if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+	}
+	if !data.Valid() {
+		return nh.render.RenderPage(w, r, http.StatusUnprocessableEntity, "note-edit.html", data)
+	}
+
+	key := fmt.Sprintf("%d/%s", noteId, utils.GenerateTokenKey())
+	size, err := nh.storage.Save(r.Context(), key, io.LimitReader(file, maxAttachmentSize))
+	if err != nil {
+		return err
+	}
+
+	attachment := models.Attachment{
+		StorageKey:  pgtype.Text{String: key, Valid: true},
+		FileName:    pgtype.Text{String: attachmentName(header.Filename), Valid: true},
+		ContentType: pgtype.Text{String: contentType, Valid: true},
+		Size:        pgtype.Int8{Int64: size, Valid: true},
+	}
+	if err := nh.attachmentRepo.Create(r.Context(), ownerId, noteId, &attachment); err != nil {
+		if err := nh.storage.Delete(r.Context(), key); err != nil {
+			slog.Error(err.Error())
+		}
+		return noteError(err)
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/note/%d/edit", noteId), http.StatusSeeOther)
+	return nil
+}
+
+// NoteAttachmentDownload serves an attachment to anyone who can read the
+// note. Images are shown inline for the previews, other files are
+// downloaded.
+func (nh *noteHandler) NoteAttachmentDownload(w http.ResponseWriter, r *http.Request) error {
+	id, err := strconvInt64(r.PathValue("id"))
+	if err != nil {
+		return err
+	}
+	attachmentId, err := strconvInt64(r.PathValue("attachment"))
+	if err != nil {
+		return err
+	}
+
+	note, err := nh.noteWithRole(r, id)
+	if err != nil {
+		return err
+	}
+	attachment, err := nh.attachmentRepo.GetById(r.Context(), note.UserId.Int.Int64(), id, attachmentId)
+	if err != nil {
+		return noteError(err)
+	}
+
+	file, err := nh.storage.Open(r.Context(), attachment.StorageKey.String)
+	if errors.Is(err, storage.ErrNotFound) {
+		slog.Error(fmt.Sprintf("missing stored file for %s", attachment))
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	disposition := "attachment"
+	if attachment.IsImage() {
+		disposition = "inline"
+	}
+	w.Header().Set("Content-Type", attachment.ContentType.String)
+	w.Header().Set("Content-Length", strconv.FormatInt(attachment.Size.Int64, 10))
+	w.Header().Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": attachment.FileName.String}))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; sandbox")
+	w.Header().Set("Cache-Control", "private, max-age=3600")
+
+	if _, err := io.Copy(w, file); err != nil {
+		slog.Error(err.Error())
+		panic(http.ErrAbortHandler)
+	}
+	return nil
+}
+
+// NoteAttachmentDelete removes an attachment. A stored file that cannot
+// be removed now is left for the storage sweep.
+func (nh *noteHandler) NoteAttachmentDelete(w http.ResponseWriter, r *http.Request) error {
+	note, err := nh.noteForChange(r)
+	if err != nil {
+		return err
+	}
+	attachmentId, err := strconvInt64(r.PathValue("attachment"))
+	if err != nil {
+		return err
+	}
+
+	attachment, err := nh.attachmentRepo.Delete(r.Context(), note.UserId.Int.Int64(), note.Id.Int.Int64(), attachmentId)
+	if err != nil {
+		return noteError(err)
+	}
+	if err := nh.storage.Delete(r.Context(), attachment.StorageKey.String); err != nil {
+		slog.Error(err.Error())
+	}
+
+	return nil
+}
@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	appError "github.com/rudsonalves/quicknotes/internal/app_error"
+)
+
+var ErrInvalidItemPosition = appError.WithStatus(errors.New("posição do item inválida"), http.StatusBadRequest)
+
+// NoteChecklistToggle checks or unchecks an item of a checklist note. It
+// is called from the note page and answers with the item as JSON.
+func (nh *noteHandler) NoteChecklistToggle(w http.ResponseWriter, r *http.Request) error {
+	note, err := nh.noteForChange(r)
+	if err != nil {
+		return err
+	}
+	itemId, err := strconvInt64(r.PathValue("item"))
+	if err != nil {
+		return err
+	}
+
+	item, err := nh.checklistRepo.Toggle(r.Context(), note.UserId.Int.Int64(), note.Id.Int.Int64(), itemId)
+	if err != nil {
+		return noteError(err)
+	}
+
+	return writeJSON(w, http.StatusOK, newChecklistItemResponse(item))
+}
+
+// NoteChecklistMove moves an item of a checklist note to the position
+// sent in the form, counted from zero.
+func (nh *noteHandler) NoteChecklistMove(w http.ResponseWriter, r *http.Request) error {
+	note, err := nh.noteForChange(r)
+	if err != nil {
+		return err
+	}
+	itemId, err := strconvInt64(r.PathValue("item"))
+	if err != nil {
+		return err
+	}
+	position, err := strconv.Atoi(r.PostFormValue("position"))
+	if err != nil || position < 0 {
+		return ErrInvalidItemPosition
+	}
+
+	if err := nh.checklistRepo.Move(r.Context(), note.UserId.Int.Int64(), note.Id.Int.Int64(), itemId, position); err != nil {
+		return noteError(err)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
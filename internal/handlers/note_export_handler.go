@@ -0,0 +1,179 @@
+package handlers
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/rudsonalves/quicknotes/internal/frontmatter"
+	"github.com/rudsonalves/quicknotes/internal/models"
+)
+
+const maxExportNameLength = 50
+
+// noteExport is a note as written by the JSON export.
+type noteExport struct {
+	Id        int64      `json:"id"`
+	Title     string     `json:"title"`
+	Content   string     `json:"content"`
+	Color     string     `json:"color"`
+	Pinned    bool       `json:"pinned"`
+	Archived  bool       `json:"archived"`
+	Tags      []string   `json:"tags"`
+	CreatedAt *time.Time `json:"created_at"`
+	UpdatedAt *time.Time `json:"updated_at"`
+}
+
+func optionalTime(ts pgtype.Timestamp) *time.Time {
+	if !ts.Valid {
+		return nil
+	}
+	return &ts.Time
+}
+
+func newNoteExport(note *models.Note) noteExport {
+	export := noteExport{
+		Id:        note.Id.Int.Int64(),
+		Title:     note.Title.String,
+		Content:   note.Content.String,
+		Color:     note.Color.String,
+		Pinned:    note.Pinned.Bool,
+		Archived:  note.Archived.Bool,
+		Tags:      note.Tags,
+		CreatedAt: optionalTime(note.CreatedAt),
+		UpdatedAt: optionalTime(note.UpdatedAt),
+	}
+	if export.Tags == nil {
+		export.Tags = []string{}
+	}
+	return export
+}
+
+// exportFileName names the Markdown file of a note after its id and a
+// slug of its title, so names never clash inside the archive.
+func exportFileName(note *models.Note) string {
+	var slug strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(note.Title.String) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			slug.WriteRune(r)
+			dash = false
+		} else if !dash && slug.Len() > 0 {
+			slug.WriteRune('-')
+			dash = true
+		}
+		if slug.Len() >= maxExportNameLength {
+			break
+		}
+	}
+
+	name := strings.Trim(slug.String(), "-")
+	if name == "" {
+		name = "nota"
+	}
+	return fmt.Sprintf("%d-%s.md", note.Id.Int.Int64(), name)
+}
+
+// exportWriter sends the attachment headers with the first byte of the
+// export, so a failure before it can still be answered with an error page.
+type exportWriter struct {
+	w           http.ResponseWriter
+	contentType string
+	fileName    string
+	started     bool
+}
+
+func (ew *exportWriter) Write(p []byte) (int, error) {
+	if !ew.started {
+		ew.started = true
+		ew.w.Header().Set("Content-Type", ew.contentType)
+		ew.w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, ew.fileName))
+	}
+	return ew.w.Write(p)
+}
+
+// NoteExport streams every note of the user as a ZIP archive of Markdown
+// files with YAML front matter, or as a JSON array with ?format=json.
+func (nh *noteHandler) NoteExport(w http.ResponseWriter, r *http.Request) error {
+	userId := nh.getUserIdFromSession(r)
+	fileName := "quicknotes-" + time.Now().Format("2006-01-02")
+
+	var err error
+	var out *exportWriter
+	if r.URL.Query().Get("format") == "json" {
+		out = &exportWriter{w: w, contentType: "application/json", fileName: fileName + ".json"}
+		err = nh.exportJSON(out, r, userId)
+	} else {
+		out = &exportWriter{w: w, contentType: "application/zip", fileName: fileName + ".zip"}
+		err = nh.exportZip(out, r, userId)
+	}
+
+	if err == nil || !out.started {
+		return err
+	}
+
+	// the response is already on its way, so abort it instead of letting
+	// the client keep a truncated file as if it was complete
+	slog.Error(err.Error())
+	panic(http.ErrAbortHandler)
+}
+
+func (nh *noteHandler) exportZip(w io.Writer, r *http.Request, userId int64) error {
+	archive := zip.NewWriter(w)
+	err := nh.repo.Export(r.Context(), userId, func(note *models.Note) error {
+		header := &zip.FileHeader{Name: exportFileName(note), Method: zip.Deflate}
+		if note.UpdatedAt.Valid {
+			header.Modified = note.UpdatedAt.Time
+		} else if note.CreatedAt.Valid {
+			header.Modified = note.CreatedAt.Time
+		}
+
+		file, err := archive.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+		return frontmatter.Write(file, frontmatter.Meta{
+			Title:     note.Title.String,
+			Color:     note.Color.String,
+			Tags:      note.Tags,
+			CreatedAt: note.CreatedAt.Time,
+			UpdatedAt: note.UpdatedAt.Time,
+		}, note.Content.String)
+	})
+	if err != nil {
+		return err
+	}
+	return archive.Close()
+}
+
+func (nh *noteHandler) exportJSON(w io.Writer, r *http.Request, userId int64) error {
+	encoder := json.NewEncoder(w)
+	separator := "["
+	err := nh.repo.Export(r.Context(), userId, func(note *models.Note) error {
+		if _, err := fmt.Fprint(w, separator); err != nil {
+			return err
+		}
+		separator = ","
+		return encoder.Encode(newNoteExport(note))
+	})
+	if err != nil {
+		return err
+	}
+
+	// an export without notes is still a valid, empty array
+	if separator == "[" {
+		_, err = fmt.Fprint(w, separator)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintln(w, "]")
+	return err
+}
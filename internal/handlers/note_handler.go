@@ -1,37 +1,80 @@
 package handlers
 
 import (
+	"encoding/base64"
+	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
+	"unicode/utf8"
 
 	"github.com/alexedwards/scs/v2"
+	"github.com/jackc/pgx/v5/pgtype"
+	appError "github.com/rudsonalves/quicknotes/internal/app_error"
 	"github.com/rudsonalves/quicknotes/internal/models"
+	"github.com/rudsonalves/quicknotes/internal/ratelimit"
 	"github.com/rudsonalves/quicknotes/internal/render"
 	"github.com/rudsonalves/quicknotes/internal/repositories"
+	"github.com/rudsonalves/quicknotes/internal/storage"
+	"github.com/rudsonalves/quicknotes/utils"
 )
 
 type noteHandler struct {
-	repo    repositories.NoteRepository
-	session *scs.SessionManager
-	render  *render.RenderTemplate
+	repo           repositories.NoteRepository
+	shareRepo      repositories.NoteShareRepository
+	linkRepo       repositories.NoteLinkRepository
+	notebookRepo   repositories.NotebookRepository
+	attachmentRepo repositories.AttachmentRepository
+	checklistRepo  repositories.ChecklistRepository
+	templateRepo   repositories.NoteTemplateRepository
+	storage        storage.Storage
+	session        *scs.SessionManager
+	render         *render.RenderTemplate
+	linkByToken    *ratelimit.Limiter
+	linkByIP       *ratelimit.Limiter
 }
 
 func NewNoteHandler(
 	session *scs.SessionManager,
 	noteRepo repositories.NoteRepository,
-	render *render.RenderTemplate) *noteHandler {
+	shareRepo repositories.NoteShareRepository,
+	linkRepo repositories.NoteLinkRepository,
+	notebookRepo repositories.NotebookRepository,
+	attachmentRepo repositories.AttachmentRepository,
+	checklistRepo repositories.ChecklistRepository,
+	templateRepo repositories.NoteTemplateRepository,
+	storage storage.Storage,
+	render *render.RenderTemplate,
+	attempts ratelimit.Store) *noteHandler {
 	return &noteHandler{
-		repo:    noteRepo,
-		session: session,
-		render:  render}
+		repo:           noteRepo,
+		shareRepo:      shareRepo,
+		linkRepo:       linkRepo,
+		notebookRepo:   notebookRepo,
+		attachmentRepo: attachmentRepo,
+		checklistRepo:  checklistRepo,
+		templateRepo:   templateRepo,
+		storage:        storage,
+		session:        session,
+		render:         render,
+		linkByToken:    ratelimit.NewLimiter(attempts, "link-token", linkTokenPolicy),
+		linkByIP:       ratelimit.NewLimiter(attempts, "link-ip", linkIPPolicy)}
 }
 
 func (nh *noteHandler) getUserIdFromSession(r *http.Request) int64 {
 	return nh.session.GetInt64(r.Context(), "userId")
 }
 
+// userLocation is the time zone chosen by the user, in which reminders
+// are entered and shown and template dates are filled in.
+func (nh *noteHandler) userLocation(r *http.Request) *time.Location {
+	return models.Location(nh.session.GetString(r.Context(), "userTimezone"))
+}
+
 func strconvInt64(sValue string) (int64, error) {
 	value, err := strconv.ParseInt(sValue, 10, 64)
 	if err != nil {
@@ -40,13 +83,246 @@ func strconvInt64(sValue string) (int64, error) {
 	return value, nil
 }
 
+const (
+	maxTagsPerNote = 10
+	maxTagLength   = 30
+
+	minLinkPasswordLength = 8
+
+	upcomingReminders = 5
+
+	maxChecklistItems = 200
+)
+
+// The passwords of public links are counted by link, so one link cannot
+// be guessed from many addresses, and by client address, so one client
+// cannot try many links.
+var (
+	linkTokenPolicy = ratelimit.Policy{
+		FreeFailures: 5,
+		BaseDelay:    time.Second,
+		MaxDelay:     time.Minute,
+		MaxFailures:  20,
+		Lockout:      time.Hour,
+		Window:       time.Hour,
+	}
+	linkIPPolicy = ratelimit.Policy{
+		FreeFailures: 10,
+		BaseDelay:    time.Second,
+		MaxDelay:     time.Minute,
+		MaxFailures:  100,
+		Lockout:      time.Hour,
+		Window:       time.Hour,
+	}
+)
+
+var ErrNoteConflict = appError.WithStatus(errors.New("a anotação foi alterada por outra atualização"), http.StatusConflict)
+var ErrInvalidNoteVersion = appError.WithStatus(errors.New("versão da anotação inválida"), http.StatusUnprocessableEntity)
+var ErrNoteForbidden = appError.WithStatus(errors.New("você não tem permissão para alterar esta anotação"), http.StatusForbidden)
+
+var ErrInvalidTags = fmt.Errorf("informe até %d tags com até %d caracteres cada", maxTagsPerNote, maxTagLength)
+
+var ErrInvalidReminder = errors.New("data do lembrete inválida")
+var ErrReminderInPast = errors.New("o lembrete deve ser em uma data futura")
+
+// parseReminder reads a datetime-local value in the time zone loc of the
+// user. An empty value removes the reminder. A new reminder must be in the
+// future, but a stored one is kept even after it was sent.
+func parseReminder(value string, stored pgtype.Timestamptz, loc *time.Location) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	remindAt, err := time.ParseInLocation(reminderInputLayout, value, loc)
+	if err != nil {
+		return nil, ErrInvalidReminder
+	}
+	if stored.Valid && stored.Time.Equal(remindAt) {
+		return &remindAt, nil
+	}
+	if remindAt.Before(time.Now()) {
+		return nil, ErrReminderInPast
+	}
+	return &remindAt, nil
+}
+
+// normalizeTag lowers and trims a tag name, collapsing inner spaces.
+func normalizeTag(tag string) string {
+	return strings.ToLower(strings.Join(strings.Fields(strings.TrimPrefix(strings.TrimSpace(tag), "#")), " "))
+}
+
+// parseTags normalizes the tag names, dropping empty and repeated ones.
+func parseTags(tags []string) ([]string, error) {
+	parsed := []string{}
+	for _, tag := range tags {
+		tag = normalizeTag(tag)
+		if tag == "" || slices.Contains(parsed, tag) {
+			continue
+		}
+		if utf8.RuneCountInString(tag) > maxTagLength {
+			return nil, ErrInvalidTags
+		}
+		parsed = append(parsed, tag)
+	}
+	if len(parsed) > maxTagsPerNote {
+		return nil, ErrInvalidTags
+	}
+	return parsed, nil
+}
+
+// noteError hides notes that do not exist or belong to another user
+// behind a 404, so their existence is not disclosed.
+func noteError(err error) error {
+	if errors.Is(err, repositories.ErrNoteNotFound) ||
+		errors.Is(err, repositories.ErrRevisionNotFound) ||
+		errors.Is(err, repositories.ErrShareNotFound) ||
+		errors.Is(err, repositories.ErrNoteLinkNotFound) ||
+		errors.Is(err, repositories.ErrAttachmentNotFound) ||
+		errors.Is(err, repositories.ErrChecklistItemNotFound) {
+		return ErrNotFound
+	}
+	if errors.Is(err, repositories.ErrNoteConflict) {
+		return ErrNoteConflict
+	}
+	return err
+}
+
+// noteWithRole loads a note the user owns or that another user shared
+// with them, telling which role the user has on it.
+func (nh *noteHandler) noteWithRole(r *http.Request, id int64) (*models.SharedNote, error) {
+	userId := nh.getUserIdFromSession(r)
+	note, err := nh.repo.GetById(r.Context(), userId, id)
+	if err == nil {
+		return &models.SharedNote{Note: *note, Role: models.NoteRoleOwner}, nil
+	}
+	if !errors.Is(err, repositories.ErrNoteNotFound) {
+		return nil, err
+	}
+
+	shared, err := nh.shareRepo.GetSharedNote(r.Context(), userId, id)
+	if err != nil {
+		return nil, noteError(err)
+	}
+	return shared, nil
+}
+
+func encodeNoteCursor(cursor *repositories.NoteCursor) string {
+	if cursor == nil {
+		return ""
+	}
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%d:%t:%s", cursor.Id, cursor.Pinned, cursor.Key)))
+}
+
+func decodeNoteCursor(value string) *repositories.NoteCursor {
+	if value == "" {
+		return nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil
+	}
+	parts := strings.SplitN(string(raw), ":", 3)
+	if len(parts) != 3 {
+		return nil
+	}
+	id, err := strconvInt64(parts[0])
+	if err != nil {
+		return nil
+	}
+	pinned, err := strconv.ParseBool(parts[1])
+	if err != nil {
+		return nil
+	}
+	return &repositories.NoteCursor{Pinned: pinned, Key: parts[2], Id: id}
+}
+
+// noteListOptionsFromQuery reads the sort, size, tag, notebook, after and
+// before query parameters, falling back to defaults on invalid values.
+func noteListOptionsFromQuery(query url.Values) (opts repositories.NoteListOptions) {
+	opts.Sort = query.Get("sort")
+	if !repositories.IsValidNoteSort(opts.Sort) {
+		opts.Sort = repositories.NoteSortUpdated
+	}
+
+	opts.Limit, _ = strconv.Atoi(query.Get("size"))
+	if opts.Limit <= 0 || opts.Limit > repositories.MaxNotePageSize {
+		opts.Limit = repositories.DefaultNotePageSize
+	}
+
+	opts.Tag = normalizeTag(query.Get("tag"))
+	if notebookId := parseNotebookId(query.Get("notebook")); notebookId != nil {
+		opts.Notebook = *notebookId
+	}
+	opts.After = decodeNoteCursor(query.Get("after"))
+	if opts.After == nil {
+		opts.Before = decodeNoteCursor(query.Get("before"))
+	}
+	return
+}
+
 func (nh *noteHandler) NoteList(w http.ResponseWriter, r *http.Request) error {
-	notes, err := nh.repo.List(r.Context(), nh.getUserIdFromSession(r))
+	return nh.renderNoteList(w, r, false)
+}
+
+func (nh *noteHandler) NoteArchive(w http.ResponseWriter, r *http.Request) error {
+	return nh.renderNoteList(w, r, true)
+}
+
+// renderNoteList renders the active notes or, when archived is true, the
+// archived ones. Search only looks at active notes.
+func (nh *noteHandler) renderNoteList(w http.ResponseWriter, r *http.Request, archived bool) error {
+	query := r.URL.Query()
+	opts := noteListOptionsFromQuery(query)
+	opts.Archived = archived
+	data := newNoteListResponse(strings.TrimSpace(query.Get("q")), opts)
+
+	if opts.Notebook > 0 {
+		notebook, err := nh.notebookRepo.GetById(r.Context(), nh.getUserIdFromSession(r), opts.Notebook)
+		if err != nil {
+			return notebookError(err)
+		}
+		resp := newNotebookResponse(notebook)
+		data.Notebook = &resp
+	}
+
+	if data.Query != "" && !archived {
+		notes, err := nh.repo.Search(r.Context(), nh.getUserIdFromSession(r), data.Query)
+		if err != nil {
+			return err
+		}
+		data.Notes = newNoteResponseFromSearchList(notes)
+	} else {
+		page, err := nh.repo.List(r.Context(), nh.getUserIdFromSession(r), opts)
+		if err != nil {
+			return err
+		}
+		data.Notes = newNoteResponseFromNoteList(page.Notes)
+		data.NextCursor = encodeNoteCursor(page.Next)
+		data.PrevCursor = encodeNoteCursor(page.Prev)
+	}
+
+	// upcoming reminders and notes shared by other users are listed
+	// above the first page only
+	if !archived && data.Query == "" && opts.Tag == "" && opts.Notebook == 0 && opts.After == nil && opts.Before == nil {
+		reminders, err := nh.repo.ListReminders(r.Context(), nh.getUserIdFromSession(r), upcomingReminders)
+		if err != nil {
+			return err
+		}
+		data.Reminders = newReminderResponseList(reminders, nh.userLocation(r))
+
+		shared, err := nh.shareRepo.ListSharedWithMe(r.Context(), nh.getUserIdFromSession(r))
+		if err != nil {
+			return err
+		}
+		data.Shared = newSharedNoteResponseList(shared)
+	}
+
+	tags, err := nh.repo.ListTags(r.Context(), nh.getUserIdFromSession(r))
 	if err != nil {
 		return err
 	}
+	data.TagCloud = newTagResponseList(tags)
 
-	return nh.render.RenderPage(w, r, http.StatusOK, "note-home.html", newNoteResponseFromNoteList(notes))
+	return nh.render.RenderPage(w, r, http.StatusOK, "note-home.html", data)
 }
 
 func (nh *noteHandler) NoteView(w http.ResponseWriter, r *http.Request) error {
@@ -59,16 +335,92 @@ func (nh *noteHandler) NoteView(w http.ResponseWriter, r *http.Request) error {
 	// ctx, cancel := context.WithTimeout(r.Context(), 300*time.Millisecond)
 	// defer cancel()
 	// note, err := nh.repo.GetById(ctx, id)
-	note, err := nh.repo.GetById(r.Context(), id)
+	note, err := nh.noteWithRole(r, id)
 	if err != nil {
 		return err
 	}
 
-	return nh.render.RenderPage(w, r, http.StatusOK, "note-view.html", newNoteResponseFromNote(note))
+	data, err := nh.newNoteView(r, note)
+	if err != nil {
+		return err
+	}
+	return nh.render.RenderPage(w, r, http.StatusOK, "note-view.html", data)
 }
 
+// newNoteView builds the note page with its attachments, listing the
+// shares and public links of the note when the user owns it.
+func (nh *noteHandler) newNoteView(r *http.Request, note *models.SharedNote) (NoteViewResponse, error) {
+	data := newNoteViewResponse(note, nh.userLocation(r))
+	attachments, err := nh.attachmentRepo.List(r.Context(), note.UserId.Int.Int64(), data.Id)
+	if err != nil {
+		return data, err
+	}
+	data.Attachments = newAttachmentResponseList(attachments)
+	if note.IsChecklist() {
+		items, err := nh.checklistRepo.List(r.Context(), note.UserId.Int.Int64(), data.Id)
+		if err != nil {
+			return data, err
+		}
+		data.Items = newChecklistItemResponseList(items)
+		data.ItemsTotal = len(items)
+		for _, item := range items {
+			if item.Checked.Bool {
+				data.ItemsDone++
+			}
+		}
+	}
+	if note.Role != models.NoteRoleOwner {
+		return data, nil
+	}
+
+	shares, err := nh.shareRepo.List(r.Context(), nh.getUserIdFromSession(r), data.Id)
+	if err != nil {
+		return data, err
+	}
+	data.Shares = newNoteShareResponseList(shares)
+
+	links, err := nh.linkRepo.List(r.Context(), nh.getUserIdFromSession(r), data.Id)
+	if err != nil {
+		return data, err
+	}
+	data.Links = newNoteLinkResponseList(links)
+	return data, nil
+}
+
+// NoteNew shows the form of a new note, filled from the template given
+// in the query with its placeholders expanded.
 func (nh *noteHandler) NoteNew(w http.ResponseWriter, r *http.Request) error {
-	return nh.render.RenderPage(w, r, http.StatusOK, "note-new.html", newNoteRequest(nil))
+	data := newNoteRequest(nil)
+	query := r.URL.Query()
+	if notebookId := parseNotebookId(query.Get("notebook")); notebookId != nil {
+		data.NotebookId = *notebookId
+	}
+	if err := nh.loadNotebooks(r, &data); err != nil {
+		return err
+	}
+
+	userId := nh.getUserIdFromSession(r)
+	templates, err := nh.templateRepo.List(r.Context(), userId)
+	if err != nil {
+		return err
+	}
+	data.Templates = newNoteTemplateResponseList(templates)
+
+	if templateId, err := strconvInt64(query.Get("template")); err == nil {
+		tmpl, err := nh.templateRepo.GetById(r.Context(), userId, templateId)
+		if err != nil {
+			return noteTemplateError(err)
+		}
+		now := time.Now().In(nh.userLocation(r))
+		email := nh.session.GetString(r.Context(), "userEmail")
+		data.TemplateId = templateId
+		data.Title = expandPlaceholders(tmpl.Title.String, now, email)
+		data.Content = expandPlaceholders(tmpl.Content.String, now, email)
+		if slices.Contains(data.Colors, tmpl.Color.String) {
+			data.Color = tmpl.Color.String
+		}
+	}
+	return nh.render.RenderPage(w, r, http.StatusOK, "note-new.html", data)
 }
 
 func (nh *noteHandler) NoteSave(w http.ResponseWriter, r *http.Request) error {
@@ -86,16 +438,80 @@ func (nh *noteHandler) NoteSave(w http.ResponseWriter, r *http.Request) error {
 	data.Color = color
 	data.Content = content
 	data.Title = title
+	data.Tags = r.PostForm.Get("tags")
+	data.RemindAt = r.PostForm.Get("remind_at")
+	data.Kind = r.PostForm.Get("kind")
+	if !models.IsValidNoteKind(data.Kind) {
+		data.Kind = models.NoteKindText
+	}
+	// an update must name the version it was edited from, or it could
+	// overwrite a concurrent one
+	version, versionErr := strconv.ParseInt(r.PostForm.Get("version"), 10, 32)
+	if id > 0 && (versionErr != nil || version <= 0) {
+		return ErrInvalidNoteVersion
+	}
+	data.Version = int32(version)
+	notebookId := parseNotebookId(r.PostForm.Get("notebook"))
+	if notebookId != nil {
+		data.NotebookId = *notebookId
+	}
+
+	// editors save the note on behalf of its owner, but only the owner
+	// may move it between the owner's notebooks
+	ownerId := nh.getUserIdFromSession(r)
+	isOwner := true
+	var storedReminder pgtype.Timestamptz
+	if id > 0 {
+		stored, err := nh.noteWithRole(r, id)
+		if err != nil {
+			return err
+		}
+		if stored.Role == models.NoteRoleViewer {
+			return ErrNoteForbidden
+		}
+		ownerId = stored.UserId.Int.Int64()
+		isOwner = stored.Role == models.NoteRoleOwner
+		storedReminder = stored.RemindAt
+	}
+	data.IsOwner = isOwner
+	if isOwner {
+		if err := nh.loadNotebooks(r, &data); err != nil {
+			return err
+		}
+	}
+
+	tags, err := parseTags(strings.Split(data.Tags, ","))
+	if err != nil {
+		data.AddFieldError("tags", err.Error())
+	}
+
+	remindAt, err := parseReminder(data.RemindAt, storedReminder, nh.userLocation(r))
+	if err != nil && isOwner {
+		data.AddFieldError("remind_at", err.Error())
+	}
 
 	// if strings.TrimSpace(title) == "" {
 	// 	data.AddFieldError("title", "Título é obrigatório")
 	// }
-	if strings.TrimSpace(content) == "" {
+	if data.Kind == models.NoteKindChecklist {
+		// checklists are saved as a task list, which is what their items
+		// are read from
+		items := models.ParseChecklist(content)
+		if len(items) == 0 {
+			data.AddFieldError("content", "Informe ao menos um item da lista, um por linha")
+		} else if len(items) > maxChecklistItems {
+			data.AddFieldError("content", fmt.Sprintf("Uma lista pode ter até %d itens", maxChecklistItems))
+		}
+		content = models.ChecklistMarkdown(items)
+	} else if strings.TrimSpace(content) == "" {
 		data.AddFieldError("content", "Conteúdo é obrigatório")
 	}
 
 	if !data.Valid() {
 		if id > 0 {
+			if err := nh.loadAttachments(r, ownerId, &data); err != nil {
+				return err
+			}
 			nh.render.RenderPage(w, r, http.StatusUnprocessableEntity, "note-edit.html", data)
 		} else {
 			nh.render.RenderPage(w, r, http.StatusUnprocessableEntity, "note-new.html", data)
@@ -103,15 +519,23 @@ func (nh *noteHandler) NoteSave(w http.ResponseWriter, r *http.Request) error {
 		return nil
 	}
 
-	var err error
-	var note *models.Note
-	if id > 0 {
-		note, err = nh.repo.Update(r.Context(), id, title, content, color)
-	} else {
-		note, err = nh.repo.Create(r.Context(), nh.getUserIdFromSession(r), title, content, color)
+	note, err := nh.repo.Save(r.Context(), ownerId, repositories.NoteInput{
+		Id:          id,
+		Version:     data.Version,
+		Title:       title,
+		Content:     content,
+		Color:       color,
+		Kind:        data.Kind,
+		Tags:        tags,
+		OwnerFields: isOwner,
+		NotebookId:  notebookId,
+		RemindAt:    remindAt,
+	})
+	if errors.Is(err, repositories.ErrNoteConflict) {
+		return nh.renderConflict(w, r, ownerId, data)
 	}
 	if err != nil {
-		return err
+		return noteError(err)
 	}
 
 	redirectUrl := fmt.Sprintf("/note/%d", note.Id.Int) // acho que aqui pode ser apenas "note/%d"
@@ -119,6 +543,62 @@ func (nh *noteHandler) NoteSave(w http.ResponseWriter, r *http.Request) error {
 	return nil
 }
 
+// loadNotebooks fills the notebooks the note may be moved to.
+func (nh *noteHandler) loadNotebooks(r *http.Request, data *NoteRequest) error {
+	notebooks, err := nh.notebookRepo.List(r.Context(), nh.getUserIdFromSession(r))
+	if err != nil {
+		return err
+	}
+	data.Notebooks = newNotebookResponseList(notebooks)
+	return nil
+}
+
+// renderConflict shows the edit form again with the submitted values and
+// the version stored by the concurrent update. The form now carries the
+// stored version, so saving it again overwrites that update.
+func (nh *noteHandler) renderConflict(w http.ResponseWriter, r *http.Request, ownerId int64, data NoteRequest) error {
+	note, err := nh.repo.GetById(r.Context(), ownerId, data.Id)
+	if err != nil {
+		return noteError(err)
+	}
+
+	if err := nh.loadAttachments(r, ownerId, &data); err != nil {
+		return err
+	}
+
+	stored := newNoteResponseFromNote(note)
+	data.Stored = &stored
+	data.Version = stored.Version
+	data.AddFieldError("version", "Esta anotação foi alterada enquanto você editava. Compare as duas versões e salve novamente.")
+	return nh.render.RenderPage(w, r, http.StatusConflict, "note-edit.html", data)
+}
+
+func (nh *noteHandler) NotePin(w http.ResponseWriter, r *http.Request) error {
+	id, err := strconvInt64(r.PathValue("id"))
+	if err != nil {
+		return err
+	}
+
+	if _, err := nh.repo.TogglePinned(r.Context(), nh.getUserIdFromSession(r), id); err != nil {
+		return noteError(err)
+	}
+
+	return nil
+}
+
+func (nh *noteHandler) NoteArchiveToggle(w http.ResponseWriter, r *http.Request) error {
+	id, err := strconvInt64(r.PathValue("id"))
+	if err != nil {
+		return err
+	}
+
+	if _, err := nh.repo.ToggleArchived(r.Context(), nh.getUserIdFromSession(r), id); err != nil {
+		return noteError(err)
+	}
+
+	return nil
+}
+
 func (nh *noteHandler) NoteDelete(w http.ResponseWriter, r *http.Request) error {
 	idParm := r.PathValue("id")
 	id, err := strconvInt64(idParm)
@@ -126,8 +606,8 @@ func (nh *noteHandler) NoteDelete(w http.ResponseWriter, r *http.Request) error
 		return err
 	}
 
-	if err := nh.repo.Delete(r.Context(), id); err != nil {
-		return err
+	if err := nh.repo.Delete(r.Context(), nh.getUserIdFromSession(r), id); err != nil {
+		return noteError(err)
 	}
 
 	return nil
@@ -140,9 +620,353 @@ func (nh *noteHandler) NoteEdit(w http.ResponseWriter, r *http.Request) error {
 		return err
 	}
 
-	note, err := nh.repo.GetById(r.Context(), id)
+	note, err := nh.noteWithRole(r, id)
+	if err != nil {
+		return err
+	}
+	if note.Role == models.NoteRoleViewer {
+		return ErrNoteForbidden
+	}
+
+	data, err := nh.newNoteEdit(r, note)
+	if err != nil {
+		return err
+	}
+	return nh.render.RenderPage(w, r, http.StatusOK, "note-edit.html", data)
+}
+
+// newNoteEdit builds the edit form of a note with its attachments, and
+// the notebooks it may be moved to when the user owns it.
+func (nh *noteHandler) newNoteEdit(r *http.Request, note *models.SharedNote) (NoteRequest, error) {
+	data := newNoteRequest(&note.Note)
+	if note.RemindAt.Valid {
+		data.RemindAt = note.RemindAt.Time.In(nh.userLocation(r)).Format(reminderInputLayout)
+	}
+	data.IsOwner = note.Role == models.NoteRoleOwner
+	if err := nh.loadAttachments(r, note.UserId.Int.Int64(), &data); err != nil {
+		return data, err
+	}
+	if note.Role == models.NoteRoleOwner {
+		if err := nh.loadNotebooks(r, &data); err != nil {
+			return data, err
+		}
+	}
+	return data, nil
+}
+
+func (nh *noteHandler) NoteHistory(w http.ResponseWriter, r *http.Request) error {
+	id, err := strconvInt64(r.PathValue("id"))
+	if err != nil {
+		return err
+	}
+
+	userId := nh.getUserIdFromSession(r)
+	note, err := nh.repo.GetById(r.Context(), userId, id)
+	if err != nil {
+		return noteError(err)
+	}
+
+	revisions, err := nh.repo.ListRevisions(r.Context(), userId, id)
+	if err != nil {
+		return noteError(err)
+	}
+
+	return nh.render.RenderPage(w, r, http.StatusOK, "note-history.html", newNoteHistoryResponse(note, revisions))
+}
+
+func (nh *noteHandler) NoteDiff(w http.ResponseWriter, r *http.Request) error {
+	id, err := strconvInt64(r.PathValue("id"))
+	if err != nil {
+		return err
+	}
+	fromId, err := strconvInt64(r.URL.Query().Get("from"))
+	if err != nil {
+		return ErrNotFound
+	}
+	toId, err := strconvInt64(r.URL.Query().Get("to"))
+	if err != nil {
+		return ErrNotFound
+	}
+	// always show the changes from the older to the newer revision
+	if fromId > toId {
+		fromId, toId = toId, fromId
+	}
+
+	userId := nh.getUserIdFromSession(r)
+	note, err := nh.repo.GetById(r.Context(), userId, id)
+	if err != nil {
+		return noteError(err)
+	}
+
+	from, err := nh.repo.GetRevision(r.Context(), userId, id, fromId)
+	if err != nil {
+		return noteError(err)
+	}
+	to, err := nh.repo.GetRevision(r.Context(), userId, id, toId)
+	if err != nil {
+		return noteError(err)
+	}
+
+	return nh.render.RenderPage(w, r, http.StatusOK, "note-diff.html", newNoteDiffResponse(note, from, to))
+}
+
+// NoteRestore copies a revision back into the note. The update itself is
+// recorded as a new revision, so the restore can be undone as well.
+func (nh *noteHandler) NoteRestore(w http.ResponseWriter, r *http.Request) error {
+	id, err := strconvInt64(r.PathValue("id"))
 	if err != nil {
 		return err
 	}
-	return nh.render.RenderPage(w, r, http.StatusOK, "note-edit.html", newNoteRequest(note))
+	revisionId, err := strconvInt64(r.PathValue("revision"))
+	if err != nil {
+		return err
+	}
+
+	userId := nh.getUserIdFromSession(r)
+	if err := nh.repo.RestoreRevision(r.Context(), userId, id, revisionId); err != nil {
+		return noteError(err)
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/note/%d", id), http.StatusSeeOther)
+	return nil
+}
+
+func (nh *noteHandler) NoteTrash(w http.ResponseWriter, r *http.Request) error {
+	notes, err := nh.repo.ListTrash(r.Context(), nh.getUserIdFromSession(r))
+	if err != nil {
+		return err
+	}
+
+	return nh.render.RenderPage(w, r, http.StatusOK, "note-trash.html", newNoteResponseFromNoteList(notes))
+}
+
+func (nh *noteHandler) NoteTrashRestore(w http.ResponseWriter, r *http.Request) error {
+	id, err := strconvInt64(r.PathValue("id"))
+	if err != nil {
+		return err
+	}
+
+	if err := nh.repo.Restore(r.Context(), nh.getUserIdFromSession(r), id); err != nil {
+		return noteError(err)
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/note/%d", id), http.StatusSeeOther)
+	return nil
+}
+
+func (nh *noteHandler) NoteTrashDelete(w http.ResponseWriter, r *http.Request) error {
+	id, err := strconvInt64(r.PathValue("id"))
+	if err != nil {
+		return err
+	}
+
+	if err := nh.repo.DeletePermanently(r.Context(), nh.getUserIdFromSession(r), id); err != nil {
+		return noteError(err)
+	}
+
+	return nil
+}
+
+func (nh *noteHandler) NoteTrashEmpty(w http.ResponseWriter, r *http.Request) error {
+	return nh.repo.EmptyTrash(r.Context(), nh.getUserIdFromSession(r))
+}
+
+// NoteShare shares the note with the user registered with the given email,
+// or changes the role of an existing share. Only the owner may share.
+func (nh *noteHandler) NoteShare(w http.ResponseWriter, r *http.Request) error {
+	id, err := strconvInt64(r.PathValue("id"))
+	if err != nil {
+		return err
+	}
+	if err := r.ParseForm(); err != nil {
+		return err
+	}
+
+	userId := nh.getUserIdFromSession(r)
+	note, err := nh.repo.GetById(r.Context(), userId, id)
+	if err != nil {
+		return noteError(err)
+	}
+
+	email := strings.TrimSpace(r.PostForm.Get("email"))
+	role := r.PostForm.Get("role")
+	if !models.IsValidShareRole(role) {
+		role = models.NoteRoleViewer
+	}
+
+	data, err := nh.newNoteView(r, &models.SharedNote{Note: *note, Role: models.NoteRoleOwner})
+	if err != nil {
+		return err
+	}
+	data.ShareEmail = email
+	data.ShareRole = role
+
+	if !utils.IsEmailValid(email) {
+		data.AddFieldError("email", "Email inválido")
+	} else if _, err := nh.shareRepo.Share(r.Context(), userId, id, email, role); err != nil {
+		// emails without an account are shared as well, so the answer does
+		// not tell which ones are registered
+		if !errors.Is(err, repositories.ErrShareWithOwner) {
+			return noteError(err)
+		}
+		data.AddFieldError("email", "Você já é o dono desta anotação")
+	}
+
+	if !data.Valid() {
+		return nh.render.RenderPage(w, r, http.StatusUnprocessableEntity, "note-view.html", data)
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/note/%d", id), http.StatusSeeOther)
+	return nil
+}
+
+func (nh *noteHandler) NoteUnshare(w http.ResponseWriter, r *http.Request) error {
+	id, err := strconvInt64(r.PathValue("id"))
+	if err != nil {
+		return err
+	}
+	shareId, err := strconvInt64(r.PathValue("share"))
+	if err != nil {
+		return err
+	}
+
+	if err := nh.shareRepo.Unshare(r.Context(), nh.getUserIdFromSession(r), id, shareId); err != nil {
+		return noteError(err)
+	}
+
+	return nil
+}
+
+// NoteLinkCreate adds a public read-only link to the note, optionally
+// protected by a password and expiring after the given number of days.
+func (nh *noteHandler) NoteLinkCreate(w http.ResponseWriter, r *http.Request) error {
+	id, err := strconvInt64(r.PathValue("id"))
+	if err != nil {
+		return err
+	}
+	if err := r.ParseForm(); err != nil {
+		return err
+	}
+
+	userId := nh.getUserIdFromSession(r)
+	note, err := nh.repo.GetById(r.Context(), userId, id)
+	if err != nil {
+		return noteError(err)
+	}
+
+	data, err := nh.newNoteView(r, &models.SharedNote{Note: *note, Role: models.NoteRoleOwner})
+	if err != nil {
+		return err
+	}
+	data.LinkExpiresInDays, _ = strconv.Atoi(r.PostForm.Get("expires"))
+	password := r.PostForm.Get("password")
+
+	if data.LinkExpiresInDays < 0 {
+		data.AddFieldError("link_expires", "Validade inválida")
+	}
+	if password != "" && utf8.RuneCountInString(password) < minLinkPasswordLength {
+		data.AddFieldError("link_password", fmt.Sprintf("A senha do link deve ter ao menos %d caracteres", minLinkPasswordLength))
+	}
+	if !data.Valid() {
+		return nh.render.RenderPage(w, r, http.StatusUnprocessableEntity, "note-view.html", data)
+	}
+
+	var passwordHash string
+	if password != "" {
+		passwordHash, err = utils.HashPassword(password)
+		if err != nil {
+			return err
+		}
+	}
+
+	var expiresAt *time.Time
+	if data.LinkExpiresInDays > 0 {
+		expires := time.Now().AddDate(0, 0, data.LinkExpiresInDays)
+		expiresAt = &expires
+	}
+
+	if _, err := nh.linkRepo.Create(r.Context(), userId, id, utils.GenerateTokenKey(), passwordHash, expiresAt); err != nil {
+		return noteError(err)
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/note/%d", id), http.StatusSeeOther)
+	return nil
+}
+
+func (nh *noteHandler) NoteLinkRevoke(w http.ResponseWriter, r *http.Request) error {
+	id, err := strconvInt64(r.PathValue("id"))
+	if err != nil {
+		return err
+	}
+	linkId, err := strconvInt64(r.PathValue("link"))
+	if err != nil {
+		return err
+	}
+
+	if err := nh.linkRepo.Revoke(r.Context(), nh.getUserIdFromSession(r), id, linkId); err != nil {
+		return noteError(err)
+	}
+
+	return nil
+}
+
+// NotePublic renders the note behind a public link. Links protected by a
+// password show a form that posts to NotePublicUnlock instead.
+func (nh *noteHandler) NotePublic(w http.ResponseWriter, r *http.Request) error {
+	token := r.PathValue("token")
+	link, note, err := nh.linkRepo.GetNoteByToken(r.Context(), token)
+	if err != nil {
+		return noteError(err)
+	}
+
+	data := newPublicNoteResponse(token)
+	if !link.HasPassword() {
+		resp := newNoteResponseFromNote(note)
+		data.Note = &resp
+	}
+	return nh.renderPublicNote(w, r, http.StatusOK, data)
+}
+
+func (nh *noteHandler) NotePublicUnlock(w http.ResponseWriter, r *http.Request) error {
+	token := r.PathValue("token")
+	link, note, err := nh.linkRepo.GetNoteByToken(r.Context(), token)
+	if err != nil {
+		return noteError(err)
+	}
+	if err := r.ParseForm(); err != nil {
+		return err
+	}
+
+	data := newPublicNoteResponse(token)
+	if link.HasPassword() {
+		wait, _, err := attempt(r.Context(), nh.linkByIP, nh.linkByToken, clientIP(r), token)
+		if err != nil {
+			return err
+		}
+		if wait > 0 {
+			return tooManyAttempts(w, wait)
+		}
+		if !utils.ValidatePassword(link.PasswordHash.String, r.PostForm.Get("password")) {
+			data.AddFieldError("password", "Senha incorreta")
+			return nh.renderPublicNote(w, r, http.StatusUnauthorized, data)
+		}
+		if err := nh.linkByIP.Forgive(r.Context(), clientIP(r)); err != nil {
+			return err
+		}
+		if err := nh.linkByToken.Forgive(r.Context(), token); err != nil {
+			return err
+		}
+	}
+
+	resp := newNoteResponseFromNote(note)
+	data.Note = &resp
+	return nh.renderPublicNote(w, r, http.StatusOK, data)
+}
+
+// renderPublicNote keeps the link token out of the referrer of outgoing
+// requests and the page out of search engines.
+func (nh *noteHandler) renderPublicNote(w http.ResponseWriter, r *http.Request, status int, data PublicNoteResponse) error {
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	w.Header().Set("X-Robots-Tag", "noindex")
+	return nh.render.RenderPage(w, r, status, "note-public.html", data)
 }
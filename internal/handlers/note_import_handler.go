@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/rudsonalves/quicknotes/internal/importer"
+	"github.com/rudsonalves/quicknotes/internal/models"
+)
+
+const (
+	maxImportMemory = 8 << 20
+	maxImportNotes  = 5000
+	maxImportBytes  = 64 << 20
+)
+
+// newImportedNote validates a note read by the importer, normalizing its
+// tags and falling back to the default color.
+func newImportedNote(note importer.Note) (models.Note, error) {
+	tags, err := parseTags(note.Tags)
+	if err != nil {
+		return models.Note{}, err
+	}
+
+	colors := noteColors()
+	color := note.Color
+	if !slices.Contains(colors, color) {
+		color = newNoteRequest(nil).Color
+	}
+
+	return models.Note{
+		Title:     pgtype.Text{String: note.Title, Valid: true},
+		Content:   pgtype.Text{String: note.Content, Valid: true},
+		Color:     pgtype.Text{String: color, Valid: true},
+		Pinned:    pgtype.Bool{Bool: note.Pinned, Valid: true},
+		Archived:  pgtype.Bool{Bool: note.Archived, Valid: true},
+		CreatedAt: pgtype.Timestamp{Time: note.CreatedAt, Valid: !note.CreatedAt.IsZero()},
+		UpdatedAt: pgtype.Timestamp{Time: note.UpdatedAt, Valid: !note.UpdatedAt.IsZero()},
+		Tags:      tags,
+	}, nil
+}
+
+func (nh *noteHandler) NoteImportForm(w http.ResponseWriter, r *http.Request) error {
+	return nh.render.RenderPage(w, r, http.StatusOK, "note-import.html", NoteImportResponse{})
+}
+
+// NoteImport imports the uploaded Markdown files, ZIP archives and Google
+// Keep notes. The notes are saved in a single transaction, and the files
+// that could not be read are listed in the report.
+func (nh *noteHandler) NoteImport(w http.ResponseWriter, r *http.Request) error {
+	data := NoteImportResponse{}
+
+	if err := r.ParseMultipartForm(maxImportMemory); err != nil {
+		data.AddFieldError("files", fmt.Sprintf("Envie até %d MB de arquivos por vez", MaxRequestBodySize>>20))
+		return nh.render.RenderPage(w, r, http.StatusRequestEntityTooLarge, "note-import.html", data)
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	uploads := r.MultipartForm.File["files"]
+	if len(uploads) == 0 {
+		data.AddFieldError("files", "Selecione ao menos um arquivo")
+		return nh.render.RenderPage(w, r, http.StatusUnprocessableEntity, "note-import.html", data)
+	}
+
+	// one budget for all the uploads, so the limits hold for the request
+	budget := importer.NewBudget(maxImportNotes, maxImportBytes)
+	var notes []models.Note
+	var files []string
+	for _, upload := range uploads {
+		file, err := upload.Open()
+		if err != nil {
+			data.addFileError(upload.Filename, err)
+			continue
+		}
+		read, errs := importer.Read(upload.Filename, file, upload.Size, budget)
+		file.Close()
+		if budget.Exceeded() {
+			break
+		}
+
+		for _, fileErr := range errs {
+			data.addFileError(fileErr.File, fileErr.Err)
+		}
+		for _, note := range read {
+			imported, err := newImportedNote(note)
+			if err != nil {
+				data.addFileError(note.File, err)
+				continue
+			}
+			notes = append(notes, imported)
+			files = append(files, note.File)
+		}
+	}
+
+	if budget.Exceeded() {
+		data.AddFieldError("files", fmt.Sprintf("Importe até %d anotações ou %d MB de anotações por vez", maxImportNotes, maxImportBytes>>20))
+		return nh.render.RenderPage(w, r, http.StatusUnprocessableEntity, "note-import.html", data)
+	}
+
+	if len(notes) > 0 {
+		if err := nh.repo.Import(r.Context(), nh.getUserIdFromSession(r), notes); err != nil {
+			slog.Error(err.Error())
+			data.AddFieldError("files", "Nenhuma anotação foi importada: ocorreu um erro ao salvar as anotações")
+			return nh.render.RenderPage(w, r, http.StatusInternalServerError, "note-import.html", data)
+		}
+	}
+
+	data.Done = true
+	data.Imported = files
+	return nh.render.RenderPage(w, r, http.StatusOK, "note-import.html", data)
+}
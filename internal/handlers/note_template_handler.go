@@ -0,0 +1,162 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/alexedwards/scs/v2"
+	"github.com/rudsonalves/quicknotes/internal/render"
+	"github.com/rudsonalves/quicknotes/internal/repositories"
+)
+
+const maxTemplateTitleLength = 100
+
+type noteTemplateHandler struct {
+	repo    repositories.NoteTemplateRepository
+	session *scs.SessionManager
+	render  *render.RenderTemplate
+}
+
+func NewNoteTemplateHandler(
+	session *scs.SessionManager,
+	templateRepo repositories.NoteTemplateRepository,
+	render *render.RenderTemplate) *noteTemplateHandler {
+	return &noteTemplateHandler{
+		repo:    templateRepo,
+		session: session,
+		render:  render}
+}
+
+func (th *noteTemplateHandler) getUserIdFromSession(r *http.Request) int64 {
+	return th.session.GetInt64(r.Context(), "userId")
+}
+
+// expandPlaceholders replaces the placeholders of a template by their
+// values at the time the note is created.
+func expandPlaceholders(text string, now time.Time, email string) string {
+	return strings.NewReplacer(
+		"{{date}}", now.Format("02/01/2006"),
+		"{{time}}", now.Format("15:04"),
+		"{{email}}", email,
+	).Replace(text)
+}
+
+func noteTemplateError(err error) error {
+	if errors.Is(err, repositories.ErrNoteTemplateNotFound) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// readNoteTemplateForm validates the fields posted by the template forms
+// into data.
+func readNoteTemplateForm(r *http.Request, data *NoteTemplateRequest) error {
+	if err := r.ParseForm(); err != nil {
+		return err
+	}
+
+	data.Title = strings.TrimSpace(r.PostForm.Get("title"))
+	data.Content = r.PostForm.Get("content")
+	if color := r.PostForm.Get("color"); slices.Contains(data.Colors, color) {
+		data.Color = color
+	}
+
+	if data.Title == "" {
+		data.AddFieldError("title", "Título é obrigatório")
+	} else if utf8.RuneCountInString(data.Title) > maxTemplateTitleLength {
+		data.AddFieldError("title", fmt.Sprintf("O título deve ter até %d caracteres", maxTemplateTitleLength))
+	}
+	return nil
+}
+
+func (th *noteTemplateHandler) TemplateList(w http.ResponseWriter, r *http.Request) error {
+	templates, err := th.repo.List(r.Context(), th.getUserIdFromSession(r))
+	if err != nil {
+		return err
+	}
+
+	return th.render.RenderPage(w, r, http.StatusOK, "templates.html", newNoteTemplateRequest(templates))
+}
+
+func (th *noteTemplateHandler) TemplateCreate(w http.ResponseWriter, r *http.Request) error {
+	userId := th.getUserIdFromSession(r)
+	templates, err := th.repo.List(r.Context(), userId)
+	if err != nil {
+		return err
+	}
+
+	data := newNoteTemplateRequest(templates)
+	if err := readNoteTemplateForm(r, &data); err != nil {
+		return err
+	}
+	if !data.Valid() {
+		return th.render.RenderPage(w, r, http.StatusUnprocessableEntity, "templates.html", data)
+	}
+
+	if _, err := th.repo.Create(r.Context(), userId, data.Title, data.Content, data.Color); err != nil {
+		return err
+	}
+
+	http.Redirect(w, r, "/templates", http.StatusSeeOther)
+	return nil
+}
+
+func (th *noteTemplateHandler) TemplateEdit(w http.ResponseWriter, r *http.Request) error {
+	id, err := strconvInt64(r.PathValue("id"))
+	if err != nil {
+		return err
+	}
+
+	tmpl, err := th.repo.GetById(r.Context(), th.getUserIdFromSession(r), id)
+	if err != nil {
+		return noteTemplateError(err)
+	}
+
+	data := newNoteTemplateRequest(nil)
+	data.Id = id
+	data.Title = tmpl.Title.String
+	data.Content = tmpl.Content.String
+	data.Color = tmpl.Color.String
+	return th.render.RenderPage(w, r, http.StatusOK, "template-edit.html", data)
+}
+
+func (th *noteTemplateHandler) TemplateUpdate(w http.ResponseWriter, r *http.Request) error {
+	id, err := strconvInt64(r.PathValue("id"))
+	if err != nil {
+		return err
+	}
+
+	data := newNoteTemplateRequest(nil)
+	data.Id = id
+	if err := readNoteTemplateForm(r, &data); err != nil {
+		return err
+	}
+	if !data.Valid() {
+		return th.render.RenderPage(w, r, http.StatusUnprocessableEntity, "template-edit.html", data)
+	}
+
+	if err := th.repo.Update(r.Context(), th.getUserIdFromSession(r), id, data.Title, data.Content, data.Color); err != nil {
+		return noteTemplateError(err)
+	}
+
+	http.Redirect(w, r, "/templates", http.StatusSeeOther)
+	return nil
+}
+
+func (th *noteTemplateHandler) TemplateDelete(w http.ResponseWriter, r *http.Request) error {
+	id, err := strconvInt64(r.PathValue("id"))
+	if err != nil {
+		return err
+	}
+
+	if err := th.repo.Delete(r.Context(), th.getUserIdFromSession(r), id); err != nil {
+		return noteTemplateError(err)
+	}
+
+	return nil
+}
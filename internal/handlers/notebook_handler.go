@@ -0,0 +1,184 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/alexedwards/scs/v2"
+	"github.com/rudsonalves/quicknotes/internal/render"
+	"github.com/rudsonalves/quicknotes/internal/repositories"
+)
+
+const maxNotebookNameLength = 50
+
+type notebookHandler struct {
+	repo    repositories.NotebookRepository
+	session *scs.SessionManager
+	render  *render.RenderTemplate
+}
+
+func NewNotebookHandler(
+	session *scs.SessionManager,
+	notebookRepo repositories.NotebookRepository,
+	render *render.RenderTemplate) *notebookHandler {
+	return &notebookHandler{
+		repo:    notebookRepo,
+		session: session,
+		render:  render}
+}
+
+func (bh *notebookHandler) getUserIdFromSession(r *http.Request) int64 {
+	return bh.session.GetInt64(r.Context(), "userId")
+}
+
+// parseNotebookId reads an optional notebook id from a form value, nil
+// meaning no notebook.
+func parseNotebookId(value string) *int64 {
+	id, err := strconvInt64(value)
+	if err != nil || id <= 0 {
+		return nil
+	}
+	return &id
+}
+
+func notebookError(err error) error {
+	if errors.Is(err, repositories.ErrNotebookNotFound) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// readNotebookForm validates the name and parent posted by the notebook
+// forms into data.
+func readNotebookForm(r *http.Request, data *NotebookRequest) (*int64, error) {
+	if err := r.ParseForm(); err != nil {
+		return nil, err
+	}
+
+	data.Name = strings.TrimSpace(r.PostForm.Get("name"))
+	parentId := parseNotebookId(r.PostForm.Get("parent"))
+	if parentId != nil {
+		data.ParentId = *parentId
+	}
+
+	if data.Name == "" {
+		data.AddFieldError("name", "Nome é obrigatório")
+	} else if utf8.RuneCountInString(data.Name) > maxNotebookNameLength {
+		data.AddFieldError("name", fmt.Sprintf("O nome deve ter até %d caracteres", maxNotebookNameLength))
+	}
+	return parentId, nil
+}
+
+func (bh *notebookHandler) NotebookList(w http.ResponseWriter, r *http.Request) error {
+	notebooks, err := bh.repo.List(r.Context(), bh.getUserIdFromSession(r))
+	if err != nil {
+		return err
+	}
+
+	return bh.render.RenderPage(w, r, http.StatusOK, "notebooks.html", newNotebookRequest(notebooks))
+}
+
+func (bh *notebookHandler) NotebookCreate(w http.ResponseWriter, r *http.Request) error {
+	userId := bh.getUserIdFromSession(r)
+	notebooks, err := bh.repo.List(r.Context(), userId)
+	if err != nil {
+		return err
+	}
+
+	data := newNotebookRequest(notebooks)
+	parentId, err := readNotebookForm(r, &data)
+	if err != nil {
+		return err
+	}
+
+	if data.Valid() {
+		_, err = bh.repo.Create(r.Context(), userId, data.Name, parentId)
+		if errors.Is(err, repositories.ErrNotebookNotFound) {
+			data.AddFieldError("parent", "Caderno pai inválido")
+		} else if err != nil {
+			return err
+		}
+	}
+
+	if !data.Valid() {
+		return bh.render.RenderPage(w, r, http.StatusUnprocessableEntity, "notebooks.html", data)
+	}
+
+	http.Redirect(w, r, "/notebooks", http.StatusSeeOther)
+	return nil
+}
+
+func (bh *notebookHandler) NotebookEdit(w http.ResponseWriter, r *http.Request) error {
+	id, err := strconvInt64(r.PathValue("id"))
+	if err != nil {
+		return err
+	}
+
+	userId := bh.getUserIdFromSession(r)
+	notebook, err := bh.repo.GetById(r.Context(), userId, id)
+	if err != nil {
+		return notebookError(err)
+	}
+	notebooks, err := bh.repo.List(r.Context(), userId)
+	if err != nil {
+		return err
+	}
+
+	data := newNotebookRequest(notebooks)
+	data.Id = id
+	data.Name = notebook.Name.String
+	data.ParentId = optionalId(notebook.ParentId)
+	return bh.render.RenderPage(w, r, http.StatusOK, "notebook-edit.html", data)
+}
+
+func (bh *notebookHandler) NotebookUpdate(w http.ResponseWriter, r *http.Request) error {
+	id, err := strconvInt64(r.PathValue("id"))
+	if err != nil {
+		return err
+	}
+
+	userId := bh.getUserIdFromSession(r)
+	notebooks, err := bh.repo.List(r.Context(), userId)
+	if err != nil {
+		return err
+	}
+
+	data := newNotebookRequest(notebooks)
+	data.Id = id
+	parentId, err := readNotebookForm(r, &data)
+	if err != nil {
+		return err
+	}
+
+	if data.Valid() {
+		_, err = bh.repo.Update(r.Context(), userId, id, data.Name, parentId)
+		if errors.Is(err, repositories.ErrNotebookCycle) {
+			data.AddFieldError("parent", "Um caderno não pode ficar dentro dele mesmo ou de um subcaderno seu")
+		} else if err != nil {
+			return notebookError(err)
+		}
+	}
+
+	if !data.Valid() {
+		return bh.render.RenderPage(w, r, http.StatusUnprocessableEntity, "notebook-edit.html", data)
+	}
+
+	http.Redirect(w, r, "/notebooks", http.StatusSeeOther)
+	return nil
+}
+
+func (bh *notebookHandler) NotebookDelete(w http.ResponseWriter, r *http.Request) error {
+	id, err := strconvInt64(r.PathValue("id"))
+	if err != nil {
+		return err
+	}
+
+	if err := bh.repo.Delete(r.Context(), bh.getUserIdFromSession(r), id); err != nil {
+		return notebookError(err)
+	}
+
+	return nil
+}
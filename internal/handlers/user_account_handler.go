@@ -0,0 +1,207 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/rudsonalves/quicknotes/internal/mailer"
+	"github.com/rudsonalves/quicknotes/internal/models"
+	"github.com/rudsonalves/quicknotes/internal/repositories"
+	"github.com/rudsonalves/quicknotes/utils"
+)
+
+const maxDisplayNameLength = 100
+
+// renderAccount renders the account page of the user with the errors of
+// one of its forms.
+func (uh *userHandler) renderAccount(w http.ResponseWriter, r *http.Request, status int, data AccountRequest) error {
+	return uh.render.RenderPage(w, r, status, "user-account.html", data)
+}
+
+func (uh *userHandler) Me(w http.ResponseWriter, r *http.Request) error {
+	user, err := uh.currentUser(r)
+	if err != nil {
+		return err
+	}
+
+	data := newAccountRequest(user)
+	data.Flash = uh.session.PopString(r.Context(), "flash")
+	return uh.renderAccount(w, r, http.StatusOK, data)
+}
+
+// AccountUpdate saves the display name and the preferences of the user.
+func (uh *userHandler) AccountUpdate(w http.ResponseWriter, r *http.Request) error {
+	if err := r.ParseForm(); err != nil {
+		return err
+	}
+
+	user, err := uh.currentUser(r)
+	if err != nil {
+		return err
+	}
+
+	name := strings.TrimSpace(r.PostFormValue("display_name"))
+	timezone := r.PostFormValue("timezone")
+
+	data := newAccountRequest(user)
+	data.DisplayName, data.Timezone = name, timezone
+
+	if utf8.RuneCountInString(name) > maxDisplayNameLength {
+		data.AddFieldError("display_name", "Nome deve ter no máximo 100 caracteres")
+	}
+	if !slices.Contains(models.Timezones, timezone) {
+		data.AddFieldError("timezone", "Fuso horário inválido")
+	}
+	if !data.Valid() {
+		return uh.renderAccount(w, r, http.StatusUnprocessableEntity, data)
+	}
+
+	user.DisplayName = pgtype.Text{String: name, Valid: name != ""}
+	user.Timezone = pgtype.Text{String: timezone, Valid: true}
+	if err := uh.repo.Update(r.Context(), user); err != nil {
+		return err
+	}
+	uh.session.Put(r.Context(), "userTimezone", timezone)
+
+	uh.session.Put(r.Context(), "flash", "Dados da conta salvos.")
+	http.Redirect(w, r, "/me", http.StatusSeeOther)
+	return nil
+}
+
+// AccountEmail starts the change of the email. The current email is kept
+// until the link mailed to the new one is opened, so a typo cannot lock
+// the user out of the account.
+func (uh *userHandler) AccountEmail(w http.ResponseWriter, r *http.Request) error {
+	if err := r.ParseForm(); err != nil {
+		return err
+	}
+
+	user, err := uh.currentUser(r)
+	if err != nil {
+		return err
+	}
+
+	email := strings.TrimSpace(r.PostFormValue("email"))
+
+	data := newAccountRequest(user)
+	validPassword, err := uh.verifyPassword(w, r, user, r.PostFormValue("password"))
+	if err != nil {
+		return err
+	}
+	if !validPassword {
+		data.AddFieldError("email_password", "Senha incorreta")
+	}
+	if !utils.IsEmailValid(email) {
+		data.AddFieldError("email", "Email inválido")
+	} else if strings.EqualFold(email, user.Email.String) {
+		data.AddFieldError("email", "O novo email deve ser diferente do atual")
+	} else if _, err := uh.repo.FindByEmail(r.Context(), email); err == nil {
+		data.AddFieldError("email", "Email já está em uso")
+	} else if !errors.Is(err, repositories.ErrEmailNotFound) {
+		return err
+	}
+	if !data.Valid() {
+		return uh.renderAccount(w, r, http.StatusUnprocessableEntity, data)
+	}
+
+	if err := uh.throttleMail(w, r, email); err != nil {
+		return err
+	}
+
+	token := utils.GenerateTokenKey()
+	if err := uh.repo.RequestEmailChange(r.Context(), user.Id.Int.Int64(), email, utils.HashToken(token)); err != nil {
+		return err
+	}
+
+	rdata := map[string]string{"token": token}
+	body, err := uh.render.RenderMailBody(r, "email-change.html", rdata)
+	if err != nil {
+		return err
+	}
+	if err := uh.mail.Send(mailer.MailMessage{
+		To:      []string{email},
+		Subject: "Confirmação de alteração de email",
+		IsHtml:  true,
+		Body:    body,
+	}); err != nil {
+		return err
+	}
+
+	uh.session.Put(r.Context(), "flash", "Enviamos um link de confirmação para "+email+". O email da conta só será alterado depois da confirmação.")
+	http.Redirect(w, r, "/me", http.StatusSeeOther)
+	return nil
+}
+
+// ConfirmEmailChange opens the link mailed to the new email. It does not
+// require a session, since the link may be opened in another browser.
+func (uh *userHandler) ConfirmEmailChange(w http.ResponseWriter, r *http.Request) error {
+	token := r.PathValue("token")
+
+	user, err := uh.repo.ConfirmEmailChange(r.Context(), utils.HashToken(token))
+	if err != nil {
+		msg := "Link inválido ou expirado. Solicite uma nova alteração."
+		if errors.Is(err, repositories.ErrDuplicateEmail) {
+			msg = "Este email já está em uso por outra conta."
+		}
+		return uh.render.RenderPage(w, r, http.StatusOK, "user-confirm.html", msg)
+	}
+
+	if uh.session.GetInt64(r.Context(), "userId") == user.Id.Int.Int64() {
+		uh.session.Put(r.Context(), "userEmail", user.Email.String)
+	}
+
+	msg := "Seu email foi alterado para " + user.Email.String + "."
+	return uh.render.RenderPage(w, r, http.StatusOK, "user-confirm.html", msg)
+}
+
+// AccountPassword changes the password, which requires the current one.
+func (uh *userHandler) AccountPassword(w http.ResponseWriter, r *http.Request) error {
+	if err := r.ParseForm(); err != nil {
+		return err
+	}
+
+	user, err := uh.currentUser(r)
+	if err != nil {
+		return err
+	}
+
+	password := r.PostFormValue("new_password")
+
+	data := newAccountRequest(user)
+	validPassword, err := uh.verifyPassword(w, r, user, r.PostFormValue("current_password"))
+	if err != nil {
+		return err
+	}
+	if !validPassword {
+		data.AddFieldError("current_password", "Senha atual incorreta")
+	}
+	if !utils.IsPasswordValid(password) {
+		data.AddFieldError("new_password", "Senha deve possuir 6 ou mais caracteres com letras e números")
+	} else if password != r.PostFormValue("password_confirm") {
+		data.AddFieldError("password_confirm", "As senhas não conferem")
+	}
+	if !data.Valid() {
+		return uh.renderAccount(w, r, http.StatusUnprocessableEntity, data)
+	}
+
+	hashedPassword, err := utils.HashPassword(password)
+	if err != nil {
+		return err
+	}
+	if err := uh.repo.UpdatePassword(r.Context(), user.Id.Int.Int64(), hashedPassword); err != nil {
+		return err
+	}
+
+	// a new session token, so a stolen cookie stops working
+	if err := uh.session.RenewToken(r.Context()); err != nil {
+		return err
+	}
+
+	uh.session.Put(r.Context(), "flash", "Senha alterada.")
+	http.Redirect(w, r, "/me", http.StatusSeeOther)
+	return nil
+}
@@ -1,14 +1,16 @@
 package handlers
 
 import (
-	"fmt"
+	"context"
+	"errors"
 	"log/slog"
 	"net/http"
 	"strings"
-	"time"
 
 	"github.com/alexedwards/scs/v2"
 	"github.com/rudsonalves/quicknotes/internal/mailer"
+	"github.com/rudsonalves/quicknotes/internal/models"
+	"github.com/rudsonalves/quicknotes/internal/ratelimit"
 	"github.com/rudsonalves/quicknotes/internal/render"
 	"github.com/rudsonalves/quicknotes/internal/repositories"
 	"github.com/rudsonalves/quicknotes/utils"
@@ -19,29 +21,21 @@ type userHandler struct {
 	repo    repositories.UserRepository
 	render  *render.RenderTemplate
 	mail    mailer.MailService
+	limits  userLimiters
 }
 
 func NewUserHandler(
 	session *scs.SessionManager,
 	userRepo repositories.UserRepository,
 	render *render.RenderTemplate,
-	mail mailer.MailService) *userHandler {
+	mail mailer.MailService,
+	attempts ratelimit.Store) *userHandler {
 	return &userHandler{
 		session: session,
 		repo:    userRepo,
 		render:  render,
-		mail:    mail}
-}
-
-func (uh *userHandler) Me(w http.ResponseWriter, r *http.Request) error {
-	// cookie, err := r.Cookie("session")
-	// if err != nil {
-	// 	http.Redirect(w, r, "/user/signin", http.StatusTemporaryRedirect)
-	// 	return nil
-	// }
-	// fmt.Fprintf(w, "Email: %s", cookie.Value)
-	fmt.Fprint(w, "Dados do usuário")
-	return nil
+		mail:    mail,
+		limits:  newUserLimiters(attempts)}
 }
 
 func (uh *userHandler) SigninForm(w http.ResponseWriter, r *http.Request) error {
@@ -73,6 +67,11 @@ func (uh *userHandler) Signin(w http.ResponseWriter, r *http.Request) error {
 		return uh.render.RenderPage(w, r, http.StatusUnprocessableEntity, "user-signin.html", data)
 	}
 
+	// refuse guesses too close to each other or from locked accounts
+	if err := uh.attemptSignin(w, r, data.Email); err != nil {
+		return err
+	}
+
 	// Get user by email
 	user, err := uh.repo.FindByEmail(r.Context(), data.Email)
 	if err != nil {
@@ -91,20 +90,16 @@ func (uh *userHandler) Signin(w http.ResponseWriter, r *http.Request) error {
 		data.AddFieldError("validation", "Credenciais inválidas.")
 		return uh.render.RenderPage(w, r, http.StatusUnprocessableEntity, "user-signin.html", data)
 	}
-
-	// Renew token
-	err = uh.session.RenewToken(r.Context())
-	if err != nil {
-		slog.Error(err.Error())
+	if err := uh.signinSucceeded(r, data.Email); err != nil {
 		return err
 	}
 
-	// store userId and email in session
-	uh.session.Put(r.Context(), "userId", user.Id.Int.Int64())
-	uh.session.Put(r.Context(), "userEmail", user.Email.String)
+	// ask for the second factor before signing in
+	if user.HasTOTP() {
+		return uh.startPendingSignin(w, r, user)
+	}
 
-	http.Redirect(w, r, "/note", http.StatusSeeOther)
-	return nil
+	return uh.startSession(w, r, user)
 }
 
 func (uh *userHandler) SignupForm(w http.ResponseWriter, r *http.Request) error {
@@ -140,8 +135,9 @@ func (uh *userHandler) Signup(w http.ResponseWriter, r *http.Request) error {
 		return err
 	}
 
-	hashToken := utils.GenerateTokenKey()
-	_, confirmationToken, err := uh.repo.Create(r.Context(), data.Email, hashPassword, hashToken)
+	// only the hash of the token is stored, the token itself goes by mail
+	confirmationToken := utils.GenerateTokenKey()
+	_, err = uh.repo.Create(r.Context(), data.Email, hashPassword, utils.HashToken(confirmationToken))
 	if err != nil {
 		if err == repositories.ErrDuplicateEmail {
 			data.AddFieldError("email", "Email já está em uso")
@@ -165,19 +161,80 @@ func (uh *userHandler) Signup(w http.ResponseWriter, r *http.Request) error {
 		return err
 	}
 
-	return uh.render.RenderPage(w, r, http.StatusOK, "user-signup-success.html", confirmationToken)
+	return uh.render.RenderPage(w, r, http.StatusOK, "user-signup-success.html", nil)
 }
 
 func (uh *userHandler) Confirm(w http.ResponseWriter, r *http.Request) error {
 	token := r.PathValue("token")
 	msg := "Seu cadastro foi confirmado. Agora você já pode fazer o login no sistema."
-	if err := uh.repo.ConfirmUserByToken(r.Context(), token); err != nil {
+	if err := uh.repo.ConfirmUserByToken(r.Context(), utils.HashToken(token)); err != nil {
 		msg = "Este cadastro já foi confirmado ou token inválido."
 	}
 
 	return uh.render.RenderPage(w, r, http.StatusOK, "user-confirm.html", msg)
 }
 
+func (uh *userHandler) NewConfirmationForm(w http.ResponseWriter, r *http.Request) error {
+	return uh.render.RenderPage(w, r, http.StatusOK, "new_confirmation.html", UserRequest{})
+}
+
+// NewConfirmation sends a new confirmation link to an account not
+// confirmed yet. The answer is the same whether the email has such an
+// account or not, so the form cannot be used to find out who is registered.
+func (uh *userHandler) NewConfirmation(w http.ResponseWriter, r *http.Request) error {
+	if err := r.ParseForm(); err != nil {
+		return err
+	}
+	email := strings.TrimSpace(r.PostFormValue("email"))
+
+	data := UserRequest{Email: email}
+	if !utils.IsEmailValid(email) {
+		data.AddFieldError("email", "Email inválido")
+		return uh.render.RenderPage(w, r, http.StatusUnprocessableEntity, "new_confirmation.html", data)
+	}
+
+	if err := uh.throttleMail(w, r, email); err != nil {
+		return err
+	}
+
+	user, err := uh.repo.FindByEmail(r.Context(), email)
+	if err != nil && !errors.Is(err, repositories.ErrEmailNotFound) {
+		return err
+	}
+	if err == nil && !user.Active.Bool {
+		// the mail is sent in the background, so the response takes the
+		// same time whether the account exists or not
+		background := r.Clone(context.WithoutCancel(r.Context()))
+		go func() {
+			if err := uh.sendNewConfirmation(background, user); err != nil {
+				slog.Error(err.Error())
+			}
+		}()
+	}
+
+	msg := "Se houver um cadastro aguardando confirmação para esse email, enviamos um novo link de confirmação. Os links enviados antes deixam de funcionar."
+	return uh.render.RenderPage(w, r, http.StatusOK, "generic-success.html", msg)
+}
+
+func (uh *userHandler) sendNewConfirmation(r *http.Request, user *models.User) error {
+	token := utils.GenerateTokenKey()
+	if _, err := uh.repo.NewUserConfirmationToken(r.Context(), user, utils.HashToken(token)); err != nil {
+		return err
+	}
+
+	rdata := map[string]string{"token": token}
+	body, err := uh.render.RenderMailBody(r, "confirmation.html", rdata)
+	if err != nil {
+		return err
+	}
+	return uh.mail.Send(mailer.MailMessage{
+		To:      []string{user.Email.String},
+		Subject: "Confirmação de Cadastro",
+		IsHtml:  true,
+		Body:    body,
+	})
+}
+
 func (uh *userHandler) Signout(w http.ResponseWriter, r *http.Request) error {
 	// Renew token
 	err := uh.session.RenewToken(r.Context())
@@ -187,6 +244,7 @@ func (uh *userHandler) Signout(w http.ResponseWriter, r *http.Request) error {
 	}
 
 	uh.session.Remove(r.Context(), "userId")
+	uh.clearPendingSignin(r.Context())
 	http.Redirect(w, r, "/user/signin", http.StatusSeeOther)
 	return nil
 }
@@ -199,12 +257,15 @@ func (uh *userHandler) ForgetPassword(w http.ResponseWriter, r *http.Request) er
 	// get email from form
 	email := r.PostFormValue("email")
 
+	if err := uh.throttleMail(w, r, email); err != nil {
+		return err
+	}
+
 	// generate token
-	hashToken := utils.GenerateTokenKey()
+	token := utils.GenerateTokenKey()
 
 	// insert a new record in tokens table (user_conf_tokens)
-	token, err := uh.repo.CreateResetPasswordToken(r.Context(), email, hashToken)
-	if err != nil {
+	if err := uh.repo.CreateResetPasswordToken(r.Context(), email, utils.HashToken(token)); err != nil {
 		data := UserRequest{}
 		data.Email = email
 		data.AddFieldError("email", "Email não possui cadastro válido ou confirmado")
@@ -235,9 +296,8 @@ func (uh *userHandler) ForgetPassword(w http.ResponseWriter, r *http.Request) er
 func (uh *userHandler) ResetPasswordForm(w http.ResponseWriter, r *http.Request) error {
 	token := r.PathValue("token")
 
-	userToken, err := uh.repo.GetUserConfirmationByToken(r.Context(), token)
-	elapsedTime := time.Since(userToken.CreatedAt.Time).Hours()
-	if err != nil || userToken.Confirmed.Bool || elapsedTime > 4 {
+	_, err := uh.repo.GetUserConfirmationByToken(r.Context(), utils.HashToken(token), models.TokenPurposePasswordReset)
+	if err != nil {
 		msg := "Token inválido ou expirado. Solicite uma nova alteração."
 		return uh.render.RenderPage(w, r, http.StatusOK, "generic-error.html", msg)
 	}
@@ -271,7 +331,7 @@ func (uh *userHandler) ResetPassword(w http.ResponseWriter, r *http.Request) err
 	}
 
 	// update password in database
-	email, err := uh.repo.UpdatePasswordByToken(r.Context(), hashedPassword, token)
+	email, err := uh.repo.UpdatePasswordByToken(r.Context(), hashedPassword, utils.HashToken(token))
 	if err != nil {
 		data := struct {
 			Token  string
@@ -0,0 +1,202 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	appError "github.com/rudsonalves/quicknotes/internal/app_error"
+	"github.com/rudsonalves/quicknotes/internal/mailer"
+	"github.com/rudsonalves/quicknotes/internal/models"
+	"github.com/rudsonalves/quicknotes/internal/ratelimit"
+	"github.com/rudsonalves/quicknotes/utils"
+)
+
+// The signin limiters count wrong passwords and codes, by client address
+// and by email, so neither guessing the password of one account nor trying
+// a common password on many accounts gets far. The mail limiters count
+// every request that sends a mail, so no address can be flooded.
+var (
+	signinIPPolicy = ratelimit.Policy{
+		FreeFailures: 10,
+		BaseDelay:    time.Second,
+		MaxDelay:     time.Minute,
+		MaxFailures:  100,
+		Lockout:      time.Hour,
+		Window:       time.Hour,
+	}
+	signinEmailPolicy = ratelimit.Policy{
+		FreeFailures: 3,
+		BaseDelay:    time.Second,
+		MaxDelay:     30 * time.Second,
+		MaxFailures:  10,
+		Lockout:      15 * time.Minute,
+		Window:       15 * time.Minute,
+	}
+	mailIPPolicy = ratelimit.Policy{
+		FreeFailures: 5,
+		BaseDelay:    time.Minute,
+		MaxDelay:     15 * time.Minute,
+		MaxFailures:  20,
+		Lockout:      time.Hour,
+		Window:       time.Hour,
+	}
+	mailEmailPolicy = ratelimit.Policy{
+		FreeFailures: 2,
+		BaseDelay:    time.Minute,
+		MaxDelay:     15 * time.Minute,
+		MaxFailures:  5,
+		Lockout:      time.Hour,
+		Window:       time.Hour,
+	}
+)
+
+type userLimiters struct {
+	signinByIP    *ratelimit.Limiter
+	signinByEmail *ratelimit.Limiter
+	mailByIP      *ratelimit.Limiter
+	mailByEmail   *ratelimit.Limiter
+}
+
+func newUserLimiters(store ratelimit.Store) userLimiters {
+	return userLimiters{
+		signinByIP:    ratelimit.NewLimiter(store, "signin-ip", signinIPPolicy),
+		signinByEmail: ratelimit.NewLimiter(store, "signin-email", signinEmailPolicy),
+		mailByIP:      ratelimit.NewLimiter(store, "mail-ip", mailIPPolicy),
+		mailByEmail:   ratelimit.NewLimiter(store, "mail-email", mailEmailPolicy),
+	}
+}
+
+// clientIP is the address the request came from. Behind a reverse proxy
+// it is the one set by ForwardedFor; the headers are not read here, since
+// any client can set them.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func limiterEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// attempt records an attempt for the client address and for the key it
+// targets, such as an email, with the given limiters. It returns the
+// longest wait of the two and whether the key was locked out by this
+// attempt.
+func attempt(ctx context.Context, byIP, byKey *ratelimit.Limiter, ip, key string) (time.Duration, bool, error) {
+	ipWait, _, err := byIP.Attempt(ctx, ip)
+	if err != nil {
+		return 0, false, err
+	}
+	keyWait, locked, err := byKey.Attempt(ctx, key)
+	if err != nil {
+		return 0, false, err
+	}
+	return max(ipWait, keyWait), locked, nil
+}
+
+// tooManyAttempts returns the 429 error rendered by HandleError, telling
+// the client when to try again.
+func tooManyAttempts(w http.ResponseWriter, wait time.Duration) error {
+	seconds := int(math.Ceil(wait.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+
+	when := fmt.Sprintf("%d segundos", seconds)
+	if seconds > 60 {
+		when = fmt.Sprintf("%d minutos", int(math.Ceil(wait.Minutes())))
+	}
+	return appError.WithStatus(fmt.Errorf("muitas tentativas. Tente novamente em %s", when), http.StatusTooManyRequests)
+}
+
+// attemptSignin counts a signin attempt before the password or code is
+// checked, so concurrent guesses cannot all get in before the failures
+// are recorded. It returns the 429 error while the client address or the
+// email must wait, and warns the owner by mail when the account is locked.
+func (uh *userHandler) attemptSignin(w http.ResponseWriter, r *http.Request, email string) error {
+	wait, locked, err := attempt(r.Context(), uh.limits.signinByIP, uh.limits.signinByEmail, clientIP(r), limiterEmail(email))
+	if err != nil {
+		return err
+	}
+	if locked {
+		slog.Warn(fmt.Sprintf("signin de %s bloqueado após muitas tentativas", email))
+		// the lookup and the mail run in the background, so the response
+		// takes the same time whether the account exists or not
+		background := r.Clone(context.WithoutCancel(r.Context()))
+		go uh.notifyLockout(background, email)
+	}
+	if wait > 0 {
+		return tooManyAttempts(w, wait)
+	}
+	return nil
+}
+
+// signinSucceeded takes back an attempt whose password or code was right.
+func (uh *userHandler) signinSucceeded(r *http.Request, email string) error {
+	if err := uh.limits.signinByIP.Forgive(r.Context(), clientIP(r)); err != nil {
+		return err
+	}
+	return uh.limits.signinByEmail.Forgive(r.Context(), limiterEmail(email))
+}
+
+// verifyPassword checks a password re-entered by a signed in user, such
+// as to change the email. It is throttled like a signin, so a stolen
+// session cannot be used to guess the password.
+func (uh *userHandler) verifyPassword(w http.ResponseWriter, r *http.Request, user *models.User, password string) (bool, error) {
+	if err := uh.attemptSignin(w, r, user.Email.String); err != nil {
+		return false, err
+	}
+	if !utils.ValidatePassword(user.Password.String, password) {
+		return false, nil
+	}
+	return true, uh.signinSucceeded(r, user.Email.String)
+}
+
+// notifyLockout mails the owner of a locked account. Nothing is sent to
+// unknown addresses, and failures are only logged.
+func (uh *userHandler) notifyLockout(r *http.Request, email string) {
+	user, err := uh.repo.FindByEmail(r.Context(), email)
+	if err != nil || !user.Active.Bool {
+		return
+	}
+
+	rdata := map[string]string{
+		"minutes": strconv.Itoa(int(signinEmailPolicy.Lockout.Minutes())),
+		"address": clientIP(r),
+	}
+	body, err := uh.render.RenderMailBody(r, "lockout.html", rdata)
+	if err != nil {
+		slog.Error(err.Error())
+		return
+	}
+
+	if err := uh.mail.Send(mailer.MailMessage{
+		To:      []string{user.Email.String},
+		Subject: "Acesso bloqueado temporariamente",
+		IsHtml:  true,
+		Body:    body,
+	}); err != nil {
+		slog.Error(err.Error())
+	}
+}
+
+// throttleMail counts a request that sends a mail to email, returning the
+// 429 error once the client address or the email sent too many.
+func (uh *userHandler) throttleMail(w http.ResponseWriter, r *http.Request, email string) error {
+	wait, _, err := attempt(r.Context(), uh.limits.mailByIP, uh.limits.mailByEmail, clientIP(r), limiterEmail(email))
+	if err != nil {
+		return err
+	}
+	if wait > 0 {
+		return tooManyAttempts(w, wait)
+	}
+	return nil
+}
@@ -0,0 +1,351 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/rudsonalves/quicknotes/internal/models"
+	"github.com/rudsonalves/quicknotes/internal/qrcode"
+	"github.com/rudsonalves/quicknotes/internal/totp"
+	"github.com/rudsonalves/quicknotes/utils"
+)
+
+const (
+	totpIssuer        = "Quicknotes"
+	recoveryCodeCount = 10
+
+	// pendingSigninTimeout is how long a user who entered the password has
+	// to enter the code, and maxSigninCodeAttempts how many wrong codes are
+	// accepted before the password must be entered again.
+	pendingSigninTimeout  = 5 * time.Minute
+	maxSigninCodeAttempts = 5
+)
+
+var recoveryCodeEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// generateRecoveryCodes returns new recovery codes, shown once to the
+// user, and their hashes, which are the only thing stored. The codes are
+// short enough to type, so they are hashed with bcrypt like passwords,
+// which keeps a leaked hash from being reversed by brute force.
+func generateRecoveryCodes() (codes, hashes []string, err error) {
+	for range recoveryCodeCount {
+		raw := make([]byte, 5)
+		rand.Read(raw)
+		code := strings.ToLower(recoveryCodeEncoding.EncodeToString(raw))
+		hash, err := utils.HashPassword(code)
+		if err != nil {
+			return nil, nil, err
+		}
+		codes = append(codes, code[:4]+"-"+code[4:])
+		hashes = append(hashes, hash)
+	}
+	return codes, hashes, nil
+}
+
+// normalizeRecoveryCode accepts the codes as typed, in any case and with
+// or without the separators.
+func normalizeRecoveryCode(code string) string {
+	return strings.NewReplacer("-", "", " ", "").Replace(strings.ToLower(strings.TrimSpace(code)))
+}
+
+// formatTOTPSecret splits the secret in groups of four characters, which
+// is easier to type in an authenticator app.
+func formatTOTPSecret(secret string) string {
+	var groups []string
+	for len(secret) > 4 {
+		groups = append(groups, secret[:4])
+		secret = secret[4:]
+	}
+	return strings.Join(append(groups, secret), " ")
+}
+
+// startSession signs the user in, after the password and, when enabled,
+// the second factor were verified.
+func (uh *userHandler) startSession(w http.ResponseWriter, r *http.Request, user *models.User) error {
+	// Renew token
+	err := uh.session.RenewToken(r.Context())
+	if err != nil {
+		slog.Error(err.Error())
+		return err
+	}
+	uh.clearPendingSignin(r.Context())
+	if err := uh.limits.signinByEmail.Reset(r.Context(), limiterEmail(user.Email.String)); err != nil {
+		return err
+	}
+
+	// store userId and email in session
+	uh.session.Put(r.Context(), "userId", user.Id.Int.Int64())
+	uh.session.Put(r.Context(), "userEmail", user.Email.String)
+	uh.session.Put(r.Context(), "userTimezone", user.Timezone.String)
+
+	http.Redirect(w, r, "/note", http.StatusSeeOther)
+	return nil
+}
+
+// startPendingSignin keeps the user half authenticated, until the code of
+// the second factor is entered. RequireAuth only accepts "userId", so the
+// pending user cannot reach any other page.
+func (uh *userHandler) startPendingSignin(w http.ResponseWriter, r *http.Request, user *models.User) error {
+	err := uh.session.RenewToken(r.Context())
+	if err != nil {
+		slog.Error(err.Error())
+		return err
+	}
+
+	uh.session.Put(r.Context(), "pendingUserId", user.Id.Int.Int64())
+	uh.session.Put(r.Context(), "pendingSince", time.Now().Unix())
+	uh.session.Remove(r.Context(), "pendingAttempts")
+
+	http.Redirect(w, r, "/user/signin/2fa", http.StatusSeeOther)
+	return nil
+}
+
+func (uh *userHandler) clearPendingSignin(ctx context.Context) {
+	uh.session.Remove(ctx, "pendingUserId")
+	uh.session.Remove(ctx, "pendingSince")
+	uh.session.Remove(ctx, "pendingAttempts")
+}
+
+// pendingUserId returns the user waiting to enter the second factor, if
+// the password was entered recently enough.
+func (uh *userHandler) pendingUserId(r *http.Request) (int64, bool) {
+	userId := uh.session.GetInt64(r.Context(), "pendingUserId")
+	since := time.Unix(uh.session.GetInt64(r.Context(), "pendingSince"), 0)
+	if userId == 0 || time.Since(since) > pendingSigninTimeout {
+		uh.clearPendingSignin(r.Context())
+		return 0, false
+	}
+	return userId, true
+}
+
+// checkSecondFactor accepts either a code of the authenticator app or an
+// unused recovery code. Both are good for a single signin.
+func (uh *userHandler) checkSecondFactor(ctx context.Context, user *models.User, code string) (bool, error) {
+	if !user.HasTOTP() {
+		return false, nil
+	}
+	userId := user.Id.Int.Int64()
+
+	if step, ok := totp.Validate(user.TOTPSecret.String, code, time.Now()); ok {
+		return uh.repo.UseTOTPStep(ctx, userId, step)
+	}
+
+	code = normalizeRecoveryCode(code)
+	if code == "" {
+		return false, nil
+	}
+	return uh.repo.UseRecoveryCode(ctx, userId, code)
+}
+
+func (uh *userHandler) SigninCodeForm(w http.ResponseWriter, r *http.Request) error {
+	if _, ok := uh.pendingUserId(r); !ok {
+		http.Redirect(w, r, "/user/signin", http.StatusSeeOther)
+		return nil
+	}
+	return uh.render.RenderPage(w, r, http.StatusOK, "user-signin-2fa.html", UserRequest{})
+}
+
+func (uh *userHandler) SigninCode(w http.ResponseWriter, r *http.Request) error {
+	userId, ok := uh.pendingUserId(r)
+	if !ok {
+		uh.session.Put(r.Context(), "flash", "O tempo para informar o código expirou. Entre novamente.")
+		http.Redirect(w, r, "/user/signin", http.StatusSeeOther)
+		return nil
+	}
+
+	if err := r.ParseForm(); err != nil {
+		return err
+	}
+
+	user, err := uh.repo.FindById(r.Context(), userId)
+	if err != nil {
+		return err
+	}
+
+	if err := uh.attemptSignin(w, r, user.Email.String); err != nil {
+		return err
+	}
+
+	valid, err := uh.checkSecondFactor(r.Context(), user, r.PostFormValue("code"))
+	if err != nil {
+		return err
+	}
+	if valid {
+		if err := uh.signinSucceeded(r, user.Email.String); err != nil {
+			return err
+		}
+		return uh.startSession(w, r, user)
+	}
+
+	attempts := uh.session.GetInt(r.Context(), "pendingAttempts") + 1
+	if attempts >= maxSigninCodeAttempts {
+		slog.Warn("muitos códigos de autenticação inválidos", "userId", userId)
+		uh.clearPendingSignin(r.Context())
+		uh.session.Put(r.Context(), "flash", "Muitos códigos inválidos. Entre novamente.")
+		http.Redirect(w, r, "/user/signin", http.StatusSeeOther)
+		return nil
+	}
+	uh.session.Put(r.Context(), "pendingAttempts", attempts)
+
+	data := UserRequest{}
+	data.AddFieldError("code", "Código inválido")
+	return uh.render.RenderPage(w, r, http.StatusUnprocessableEntity, "user-signin-2fa.html", data)
+}
+
+// newTwoFactorRequest fills the two-factor page of the user. While it is
+// disabled, a secret is kept in the session until the enrollment is
+// confirmed with a code, so reloading the page shows the same QR code.
+func (uh *userHandler) newTwoFactorRequest(r *http.Request, user *models.User) (data TwoFactorRequest, err error) {
+	if user.HasTOTP() {
+		data.Enabled = true
+		data.RemainingCodes, err = uh.repo.CountRecoveryCodes(r.Context(), user.Id.Int.Int64())
+		return
+	}
+
+	secret := uh.session.GetString(r.Context(), "totpSecret")
+	if secret == "" {
+		secret = totp.GenerateSecret()
+		uh.session.Put(r.Context(), "totpSecret", secret)
+	}
+
+	data.Secret = formatTOTPSecret(secret)
+	uri := totp.ProvisioningURI(totpIssuer, user.Email.String, secret)
+	data.ProvisioningURI = template.URL(uri)
+	// addresses too long for a QR code can still type the secret
+	if code, err := qrcode.Encode(uri); err == nil {
+		data.QRCode = template.HTML(code.SVG(4))
+	}
+	return
+}
+
+func (uh *userHandler) currentUser(r *http.Request) (*models.User, error) {
+	return uh.repo.FindById(r.Context(), uh.session.GetInt64(r.Context(), "userId"))
+}
+
+func (uh *userHandler) TwoFactor(w http.ResponseWriter, r *http.Request) error {
+	user, err := uh.currentUser(r)
+	if err != nil {
+		return err
+	}
+
+	data, err := uh.newTwoFactorRequest(r, user)
+	if err != nil {
+		return err
+	}
+	data.Flash = uh.session.PopString(r.Context(), "flash")
+	return uh.render.RenderPage(w, r, http.StatusOK, "user-2fa.html", data)
+}
+
+// TwoFactorEnable confirms the enrollment with a code of the app and
+// shows the recovery codes, which cannot be seen again.
+func (uh *userHandler) TwoFactorEnable(w http.ResponseWriter, r *http.Request) error {
+	if err := r.ParseForm(); err != nil {
+		return err
+	}
+
+	user, err := uh.currentUser(r)
+	if err != nil {
+		return err
+	}
+	secret := uh.session.GetString(r.Context(), "totpSecret")
+	if user.HasTOTP() || secret == "" {
+		http.Redirect(w, r, "/me/2fa", http.StatusSeeOther)
+		return nil
+	}
+
+	step, ok := totp.Validate(secret, r.PostFormValue("code"), time.Now())
+	if !ok {
+		data, err := uh.newTwoFactorRequest(r, user)
+		if err != nil {
+			return err
+		}
+		data.AddFieldError("code", "Código inválido. Confira se o horário do seu dispositivo está correto.")
+		return uh.render.RenderPage(w, r, http.StatusUnprocessableEntity, "user-2fa.html", data)
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return err
+	}
+	if err := uh.repo.EnableTOTP(r.Context(), user.Id.Int.Int64(), secret, step, hashes); err != nil {
+		return err
+	}
+	uh.session.Remove(r.Context(), "totpSecret")
+
+	data := TwoFactorRequest{Enabled: true, RecoveryCodes: codes, RemainingCodes: len(codes)}
+	return uh.render.RenderPage(w, r, http.StatusOK, "user-2fa.html", data)
+}
+
+// checkCurrentPassword renders the two-factor page again with an error
+// when the password re-entered by the user is wrong.
+func (uh *userHandler) checkCurrentPassword(w http.ResponseWriter, r *http.Request, user *models.User) (bool, error) {
+	if ok, err := uh.verifyPassword(w, r, user, r.PostFormValue("password")); ok || err != nil {
+		return ok, err
+	}
+
+	data, err := uh.newTwoFactorRequest(r, user)
+	if err != nil {
+		return false, err
+	}
+	data.AddFieldError("password", "Senha incorreta")
+	return false, uh.render.RenderPage(w, r, http.StatusUnprocessableEntity, "user-2fa.html", data)
+}
+
+func (uh *userHandler) TwoFactorDisable(w http.ResponseWriter, r *http.Request) error {
+	if err := r.ParseForm(); err != nil {
+		return err
+	}
+
+	user, err := uh.currentUser(r)
+	if err != nil {
+		return err
+	}
+	if ok, err := uh.checkCurrentPassword(w, r, user); !ok {
+		return err
+	}
+
+	if err := uh.repo.DisableTOTP(r.Context(), user.Id.Int.Int64()); err != nil {
+		return err
+	}
+
+	uh.session.Put(r.Context(), "flash", "A autenticação em dois fatores foi desativada.")
+	http.Redirect(w, r, "/me/2fa", http.StatusSeeOther)
+	return nil
+}
+
+// TwoFactorRecoveryCodes replaces the recovery codes, for users who used
+// or lost them.
+func (uh *userHandler) TwoFactorRecoveryCodes(w http.ResponseWriter, r *http.Request) error {
+	if err := r.ParseForm(); err != nil {
+		return err
+	}
+
+	user, err := uh.currentUser(r)
+	if err != nil {
+		return err
+	}
+	if !user.HasTOTP() {
+		http.Redirect(w, r, "/me/2fa", http.StatusSeeOther)
+		return nil
+	}
+	if ok, err := uh.checkCurrentPassword(w, r, user); !ok {
+		return err
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return err
+	}
+	if err := uh.repo.ReplaceRecoveryCodes(r.Context(), user.Id.Int.Int64(), hashes); err != nil {
+		return err
+	}
+
+	data := TwoFactorRequest{Enabled: true, RecoveryCodes: codes, RemainingCodes: len(codes)}
+	return uh.render.RenderPage(w, r, http.StatusOK, "user-2fa.html", data)
+}
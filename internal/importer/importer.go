@@ -0,0 +1,287 @@
+// Package importer reads notes from Markdown files, ZIP archives and
+// Google Keep Takeout exports.
+package importer
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/rudsonalves/quicknotes/internal/frontmatter"
+)
+
+// MaxFileSize limits each file read, including the files inside ZIP
+// archives, guarding against archives that expand to huge files.
+const MaxFileSize = 1 << 20
+
+var (
+	ErrUnsupported = errors.New("formato não suportado, envie arquivos .md, .json ou .zip")
+	ErrTooLarge    = fmt.Errorf("arquivo maior que %d KB", MaxFileSize>>10)
+	ErrEmpty       = errors.New("anotação sem título e sem conteúdo")
+	ErrKeepTrashed = errors.New("anotação na lixeira do Google Keep, ignorada")
+	ErrBudget      = errors.New("limite da importação atingido, arquivo não lido")
+)
+
+// Budget bounds a whole import, which may span several uploads: at most
+// notes files are read and at most bytes bytes decompressed. It guards
+// against archives of many small entries that expand to gigabytes.
+type Budget struct {
+	notes    int
+	bytes    int64
+	exceeded bool
+}
+
+func NewBudget(notes int, bytes int64) *Budget {
+	return &Budget{notes: notes, bytes: bytes}
+}
+
+// Exceeded tells whether a file was refused because the budget ran out.
+// Once it did, nothing else is read.
+func (b *Budget) Exceeded() bool {
+	return b.exceeded
+}
+
+// limit is how many bytes the next file may have.
+func (b *Budget) limit() int64 {
+	return min(MaxFileSize, b.bytes)
+}
+
+// spend takes a file of size bytes from the budget, failing when either
+// the notes or the bytes ran out.
+func (b *Budget) spend(size int) bool {
+	if b.exceeded || b.notes <= 0 || int64(size) > b.bytes {
+		b.exceeded = true
+		return false
+	}
+	b.notes--
+	b.bytes -= int64(size)
+	return true
+}
+
+// Note is a note read from an imported file. Color is one of the color1
+// to color9 classes or empty, and zero times are unknown.
+type Note struct {
+	File      string
+	Title     string
+	Content   string
+	Color     string
+	Tags      []string
+	Pinned    bool
+	Archived  bool
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// FileError is a file that could not be imported.
+type FileError struct {
+	File string
+	Err  error
+}
+
+func (fe FileError) Error() string {
+	return fe.File + ": " + fe.Err.Error()
+}
+
+// keepColors maps the Google Keep colors onto the closest note colors.
+var keepColors = map[string]string{
+	"RED":      "color3",
+	"PINK":     "color3",
+	"ORANGE":   "color2",
+	"BROWN":    "color2",
+	"YELLOW":   "color1",
+	"GREEN":    "color9",
+	"TEAL":     "color8",
+	"BLUE":     "color6",
+	"CERULEAN": "color5",
+	"PURPLE":   "color4",
+}
+
+// keepNote is a note of a Google Keep Takeout export.
+type keepNote struct {
+	Title       string `json:"title"`
+	TextContent string `json:"textContent"`
+	Color       string `json:"color"`
+	IsTrashed   bool   `json:"isTrashed"`
+	IsPinned    bool   `json:"isPinned"`
+	IsArchived  bool   `json:"isArchived"`
+	Labels      []struct {
+		Name string `json:"name"`
+	} `json:"labels"`
+	ListContent []struct {
+		Text      string `json:"text"`
+		IsChecked bool   `json:"isChecked"`
+	} `json:"listContent"`
+	CreatedTimestampUsec    int64 `json:"createdTimestampUsec"`
+	UserEditedTimestampUsec int64 `json:"userEditedTimestampUsec"`
+}
+
+// Read reads the notes of an uploaded file, choosing the format by its
+// extension. Files that could not be read are returned as errors. Each
+// file read is taken from the budget, and reading stops when it runs out.
+func Read(name string, r io.ReaderAt, size int64, budget *Budget) ([]Note, []FileError) {
+	if budget.Exceeded() {
+		return nil, []FileError{{File: name, Err: ErrBudget}}
+	}
+	switch strings.ToLower(path.Ext(name)) {
+	case ".zip":
+		return readZip(name, r, size, budget)
+	case ".md", ".markdown", ".json":
+		if size > MaxFileSize {
+			return nil, []FileError{{File: name, Err: ErrTooLarge}}
+		}
+		note, err := readNote(name, io.NewSectionReader(r, 0, size), budget)
+		if err != nil {
+			return nil, []FileError{{File: name, Err: err}}
+		}
+		return []Note{note}, nil
+	}
+	return nil, []FileError{{File: name, Err: ErrUnsupported}}
+}
+
+// readZip reads the Markdown and Keep files of a ZIP archive. Other files,
+// like the HTML copies of a Takeout export, are silently skipped.
+func readZip(name string, r io.ReaderAt, size int64, budget *Budget) (notes []Note, errs []FileError) {
+	archive, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, []FileError{{File: name, Err: errors.New("arquivo ZIP inválido")}}
+	}
+
+	for _, entry := range archive.File {
+		ext := strings.ToLower(path.Ext(entry.Name))
+		if entry.FileInfo().IsDir() || (ext != ".md" && ext != ".markdown" && ext != ".json") {
+			continue
+		}
+
+		file := name + "/" + entry.Name
+		if entry.UncompressedSize64 > MaxFileSize {
+			errs = append(errs, FileError{File: file, Err: ErrTooLarge})
+			continue
+		}
+		content, err := entry.Open()
+		if err != nil {
+			errs = append(errs, FileError{File: file, Err: err})
+			continue
+		}
+		note, err := readNote(entry.Name, content, budget)
+		content.Close()
+		if errors.Is(err, ErrBudget) {
+			errs = append(errs, FileError{File: file, Err: err})
+			break
+		}
+		if err != nil {
+			errs = append(errs, FileError{File: file, Err: err})
+			continue
+		}
+		note.File = file
+		notes = append(notes, note)
+	}
+	return notes, errs
+}
+
+func readNote(name string, r io.Reader, budget *Budget) (Note, error) {
+	if budget.notes <= 0 {
+		budget.exceeded = true
+		return Note{}, ErrBudget
+	}
+
+	// read one byte past the limit to tell a file that is too large, and
+	// charge what was read, so even refused files use up the budget
+	data, err := io.ReadAll(io.LimitReader(r, budget.limit()+1))
+	if err != nil {
+		return Note{}, err
+	}
+	if !budget.spend(len(data)) {
+		return Note{}, ErrBudget
+	}
+	if len(data) > MaxFileSize {
+		return Note{}, ErrTooLarge
+	}
+
+	if strings.ToLower(path.Ext(name)) == ".json" {
+		return readKeep(name, data)
+	}
+	return readMarkdown(name, data)
+}
+
+// readMarkdown reads a Markdown file, taking the title, color, tags and
+// timestamps from its front matter. The title defaults to the file name.
+func readMarkdown(name string, data []byte) (Note, error) {
+	meta, body, err := frontmatter.Parse(string(data))
+	if err != nil {
+		return Note{}, err
+	}
+
+	note := Note{
+		File:      name,
+		Title:     strings.TrimSpace(meta.Title),
+		Content:   strings.TrimSpace(body),
+		Color:     meta.Color,
+		Tags:      meta.Tags,
+		CreatedAt: meta.CreatedAt,
+		UpdatedAt: meta.UpdatedAt,
+	}
+	if note.Title == "" {
+		note.Title = strings.TrimSuffix(path.Base(name), path.Ext(name))
+	}
+	return note, finish(&note)
+}
+
+// readKeep reads a note of a Google Keep Takeout export. Checklists are
+// written as Markdown task lists.
+func readKeep(name string, data []byte) (Note, error) {
+	var keep keepNote
+	if err := json.Unmarshal(data, &keep); err != nil {
+		return Note{}, errors.New("JSON do Google Keep inválido")
+	}
+	if keep.IsTrashed {
+		return Note{}, ErrKeepTrashed
+	}
+
+	content := keep.TextContent
+	if len(keep.ListContent) > 0 {
+		var list strings.Builder
+		for _, item := range keep.ListContent {
+			mark := " "
+			if item.IsChecked {
+				mark = "x"
+			}
+			fmt.Fprintf(&list, "- [%s] %s\n", mark, item.Text)
+		}
+		content = list.String()
+	}
+
+	note := Note{
+		File:     name,
+		Title:    strings.TrimSpace(keep.Title),
+		Content:  strings.TrimSpace(content),
+		Color:    keepColors[keep.Color],
+		Pinned:   keep.IsPinned,
+		Archived: keep.IsArchived,
+	}
+	for _, label := range keep.Labels {
+		note.Tags = append(note.Tags, label.Name)
+	}
+	if keep.CreatedTimestampUsec > 0 {
+		note.CreatedAt = time.UnixMicro(keep.CreatedTimestampUsec).UTC()
+	}
+	if keep.UserEditedTimestampUsec > 0 {
+		note.UpdatedAt = time.UnixMicro(keep.UserEditedTimestampUsec).UTC()
+	}
+	return note, finish(&note)
+}
+
+// finish fills an empty content with the title, as notes need content.
+func finish(note *Note) error {
+	if note.Content == "" {
+		if note.Title == "" {
+			return ErrEmpty
+		}
+		note.Content = note.Title
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package markdown
+
+import (
+	"bytes"
+	"html/template"
+	"log/slog"
+	"regexp"
+	"strings"
+
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/renderer/html"
+)
+
+const (
+	previewMaxLines = 8
+	previewMaxRunes = 400
+)
+
+// raw html in the source is dropped by goldmark (it is not built with
+// html.WithUnsafe) and the output is still sanitized by bluemonday
+var md = goldmark.New(
+	goldmark.WithExtensions(extension.GFM),
+	goldmark.WithRendererOptions(html.WithHardWraps()),
+)
+
+var policy = newPolicy()
+
+func newPolicy() *bluemonday.Policy {
+	p := bluemonday.UGCPolicy()
+	// task list checkboxes
+	p.AllowAttrs("type").Matching(regexp.MustCompile(`^checkbox$`)).OnElements("input")
+	p.AllowAttrs("checked", "disabled").Matching(regexp.MustCompile(`^$|^checked$|^disabled$`)).OnElements("input")
+	// fenced code language
+	p.AllowAttrs("class").Matching(regexp.MustCompile(`^language-[a-zA-Z0-9_+-]+$`)).OnElements("code")
+	p.AddTargetBlankToFullyQualifiedLinks(true)
+	return p
+}
+
+// Render converts markdown to sanitized HTML, safe to be used directly in
+// html/template.
+func Render(source string) template.HTML {
+	buff := &bytes.Buffer{}
+	if err := md.Convert([]byte(source), buff); err != nil {
+		slog.Error(err.Error())
+		return template.HTML(template.HTMLEscapeString(source))
+	}
+	return template.HTML(policy.SanitizeBytes(buff.Bytes()))
+}
+
+// Preview renders only the beginning of the markdown source, used in the
+// note cards.
+func Preview(source string) template.HTML {
+	lines := strings.Split(source, "\n")
+	truncated := len(lines) > previewMaxLines
+	if truncated {
+		lines = lines[:previewMaxLines]
+	}
+	preview := strings.Join(lines, "\n")
+
+	if runes := []rune(preview); len(runes) > previewMaxRunes {
+		preview = string(runes[:previewMaxRunes])
+		truncated = true
+	}
+	if truncated {
+		preview = strings.TrimRight(preview, " \n") + "…"
+	}
+
+	return Render(preview)
+}
@@ -0,0 +1,39 @@
+package models
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const (
+	ScopeNotesRead  = "notes:read"
+	ScopeNotesWrite = "notes:write"
+)
+
+// APIScopes lists every scope a personal access token may be granted.
+var APIScopes = []string{ScopeNotesRead, ScopeNotesWrite}
+
+type APIToken struct {
+	Id         pgtype.Numeric
+	UserId     pgtype.Numeric
+	Name       pgtype.Text
+	TokenHash  pgtype.Text
+	Scopes     []string
+	ExpiresAt  pgtype.Timestamp
+	LastUsedAt pgtype.Timestamp
+	CreatedAt  pgtype.Timestamp
+	// Expired is only read when listing the tokens of a user. Expired
+	// tokens are refused by the API until the user revokes them.
+	Expired bool
+}
+
+func (t APIToken) HasScope(scope string) bool {
+	return slices.Contains(t.Scopes, scope)
+}
+
+func (t APIToken) String() string {
+	return fmt.Sprintf("APIToken{Id: %d, UserId: %d, Name: %s, Scopes: %v}",
+		t.Id.Int, t.UserId.Int, t.Name.String, t.Scopes)
+}
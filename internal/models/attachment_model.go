@@ -0,0 +1,29 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// Attachment is a file uploaded to a note. Its contents live in the
+// storage backend under StorageKey.
+type Attachment struct {
+	Id          pgtype.Numeric
+	NoteId      pgtype.Numeric
+	StorageKey  pgtype.Text
+	FileName    pgtype.Text
+	ContentType pgtype.Text
+	Size        pgtype.Int8
+	CreatedAt   pgtype.Timestamp
+}
+
+func (a Attachment) IsImage() bool {
+	return strings.HasPrefix(a.ContentType.String, "image/")
+}
+
+func (a Attachment) String() string {
+	return fmt.Sprintf("Attachment{Id: %d, NoteId: %d, FileName: %s, Size: %d}",
+		a.Id.Int, a.NoteId.Int, a.FileName.String, a.Size.Int64)
+}
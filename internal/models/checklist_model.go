@@ -0,0 +1,77 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const (
+	NoteKindText      = "text"
+	NoteKindChecklist = "checklist"
+)
+
+func IsValidNoteKind(kind string) bool {
+	return kind == NoteKindText || kind == NoteKindChecklist
+}
+
+// ChecklistItem is an item of a checklist note, listed by Position.
+type ChecklistItem struct {
+	Id       pgtype.Numeric
+	NoteId   pgtype.Numeric
+	Text     pgtype.Text
+	Checked  pgtype.Bool
+	Position pgtype.Int4
+}
+
+func (ci ChecklistItem) String() string {
+	return fmt.Sprintf("ChecklistItem{Id: %d, NoteId: %d, Text: %s, Checked: %t}",
+		ci.Id.Int, ci.NoteId.Int, ci.Text.String, ci.Checked.Bool)
+}
+
+// ParseChecklist reads one item per non-empty line. Lines may be written
+// as Markdown task list items, "[x]" marking the checked ones.
+func ParseChecklist(content string) []ChecklistItem {
+	var items []ChecklistItem
+	for _, line := range strings.Split(content, "\n") {
+		text := strings.TrimSpace(line)
+		for _, bullet := range []string{"- ", "* ", "+ "} {
+			text = strings.TrimPrefix(text, bullet)
+		}
+
+		checked := false
+		switch {
+		case strings.HasPrefix(text, "[x]"), strings.HasPrefix(text, "[X]"):
+			checked = true
+			text = text[3:]
+		case strings.HasPrefix(text, "[ ]"):
+			text = text[3:]
+		}
+
+		text = strings.TrimSpace(text)
+		if text == "" {
+			continue
+		}
+		items = append(items, ChecklistItem{
+			Text:     pgtype.Text{String: text, Valid: true},
+			Checked:  pgtype.Bool{Bool: checked, Valid: true},
+			Position: pgtype.Int4{Int32: int32(len(items)), Valid: true},
+		})
+	}
+	return items
+}
+
+// ChecklistMarkdown writes the items as a Markdown task list, which is
+// kept as the content of checklist notes.
+func ChecklistMarkdown(items []ChecklistItem) string {
+	lines := make([]string, 0, len(items))
+	for _, item := range items {
+		mark := " "
+		if item.Checked.Bool {
+			mark = "x"
+		}
+		lines = append(lines, fmt.Sprintf("- [%s] %s", mark, item.Text.String))
+	}
+	return strings.Join(lines, "\n")
+}
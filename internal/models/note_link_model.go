@@ -0,0 +1,27 @@
+package models
+
+import (
+	"fmt"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// NoteLink is a public, read-only link to a note. PasswordHash is only
+// valid when the link is protected by a password.
+type NoteLink struct {
+	Id           pgtype.Numeric
+	NoteId       pgtype.Numeric
+	Token        pgtype.Text
+	PasswordHash pgtype.Text
+	ExpiresAt    pgtype.Timestamp
+	CreatedAt    pgtype.Timestamp
+}
+
+func (nl NoteLink) HasPassword() bool {
+	return nl.PasswordHash.Valid && nl.PasswordHash.String != ""
+}
+
+func (nl NoteLink) String() string {
+	return fmt.Sprintf("NoteLink{Id: %d, NoteId: %d, Expires At: %v}",
+		nl.Id.Int, nl.NoteId.Int, nl.ExpiresAt.Time)
+}
@@ -7,17 +7,52 @@ import (
 )
 
 type Note struct {
-	Id        pgtype.Numeric
-	UserId    pgtype.Numeric
-	Title     pgtype.Text
-	Content   pgtype.Text
-	Color     pgtype.Text
-	CreatedAt pgtype.Date
-	UpdatedAt pgtype.Date
+	Id         pgtype.Numeric
+	UserId     pgtype.Numeric
+	Title      pgtype.Text
+	Content    pgtype.Text
+	Color      pgtype.Text
+	NotebookId pgtype.Numeric
+	Kind       pgtype.Text
+	Version    pgtype.Int4
+	Pinned     pgtype.Bool
+	Archived   pgtype.Bool
+	RemindAt   pgtype.Timestamptz
+	CreatedAt  pgtype.Timestamp
+	UpdatedAt  pgtype.Timestamp
+	DeletedAt  pgtype.Timestamp
+	Tags       []string
+	// ItemsDone and ItemsTotal count the items of checklist notes, only
+	// filled by listings.
+	ItemsDone  int
+	ItemsTotal int
+}
+
+func (n Note) IsChecklist() bool {
+	return n.Kind.String == NoteKindChecklist
 }
 
 func (n Note) String() string {
 	return fmt.Sprintf(
-		"Note{id: %d, UserId: %d,title: %s, Content: %s, Color: %s, Created At: %v, Updated At: %v}",
-		n.Id.Int, n.UserId.Int, n.Title.String, n.Content.String, n.Color.String, n.CreatedAt.Time, n.UpdatedAt.Time)
+		"Note{id: %d, UserId: %d,title: %s, Content: %s, Color: %s, Tags: %v, Created At: %v, Updated At: %v}",
+		n.Id.Int, n.UserId.Int, n.Title.String, n.Content.String, n.Color.String, n.Tags, n.CreatedAt.Time, n.UpdatedAt.Time)
+}
+
+// NoteSearchResult is a note matched by a full-text search, with its rank
+// and a content snippet where matches are wrapped in <mark> tags.
+type NoteSearchResult struct {
+	Note
+	Rank    float32
+	Snippet pgtype.Text
+}
+
+// Reminder is a due reminder of a note, with the email of the note owner
+// it is sent to.
+type Reminder struct {
+	NoteId   pgtype.Numeric
+	Title    pgtype.Text
+	Content  pgtype.Text
+	RemindAt pgtype.Timestamptz
+	Email    pgtype.Text
+	Timezone pgtype.Text
 }
@@ -0,0 +1,21 @@
+package models
+
+import (
+	"fmt"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+type NoteRevision struct {
+	Id        pgtype.Numeric
+	NoteId    pgtype.Numeric
+	Title     pgtype.Text
+	Content   pgtype.Text
+	Color     pgtype.Text
+	CreatedAt pgtype.Timestamp
+}
+
+func (nr NoteRevision) String() string {
+	return fmt.Sprintf("NoteRevision{Id: %d, NoteId: %d, Title: %s, Created At: %v}",
+		nr.Id.Int, nr.NoteId.Int, nr.Title.String, nr.CreatedAt.Time)
+}
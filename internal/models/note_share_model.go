@@ -0,0 +1,45 @@
+package models
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const (
+	NoteRoleOwner  = "owner"
+	NoteRoleEditor = "editor"
+	NoteRoleViewer = "viewer"
+)
+
+// NoteShareRoles lists the roles a note may be shared with.
+var NoteShareRoles = []string{NoteRoleViewer, NoteRoleEditor}
+
+func IsValidShareRole(role string) bool {
+	return slices.Contains(NoteShareRoles, role)
+}
+
+// NoteShare grants another user access to a note. Email is the address
+// of the user the note is shared with.
+type NoteShare struct {
+	Id        pgtype.Numeric
+	NoteId    pgtype.Numeric
+	UserId    pgtype.Numeric
+	Email     pgtype.Text
+	Role      pgtype.Text
+	CreatedAt pgtype.Timestamp
+}
+
+func (ns NoteShare) String() string {
+	return fmt.Sprintf("NoteShare{Id: %d, NoteId: %d, UserId: %d, Role: %s}",
+		ns.Id.Int, ns.NoteId.Int, ns.UserId.Int, ns.Role.String)
+}
+
+// SharedNote is a note shared with the current user, along with the role
+// granted and the email of its owner.
+type SharedNote struct {
+	Note
+	Role       string
+	OwnerEmail string
+}
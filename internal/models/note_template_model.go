@@ -0,0 +1,24 @@
+package models
+
+import (
+	"fmt"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// NoteTemplate is a skeleton a user starts new notes from. Title and
+// Content may hold placeholders expanded when the note is created.
+type NoteTemplate struct {
+	Id        pgtype.Numeric
+	UserId    pgtype.Numeric
+	Title     pgtype.Text
+	Content   pgtype.Text
+	Color     pgtype.Text
+	CreatedAt pgtype.Timestamp
+	UpdatedAt pgtype.Timestamp
+}
+
+func (nt NoteTemplate) String() string {
+	return fmt.Sprintf("NoteTemplate{Id: %d, UserId: %d, Title: %s, Color: %s}",
+		nt.Id.Int, nt.UserId.Int, nt.Title.String, nt.Color.String)
+}
@@ -0,0 +1,24 @@
+package models
+
+import (
+	"fmt"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// Notebook groups notes. Notebooks may be nested, ParentId being invalid
+// for the top level ones. Depth and NoteCount are only filled by listings.
+type Notebook struct {
+	Id        pgtype.Numeric
+	UserId    pgtype.Numeric
+	ParentId  pgtype.Numeric
+	Name      pgtype.Text
+	CreatedAt pgtype.Timestamp
+	Depth     int
+	NoteCount int
+}
+
+func (nb Notebook) String() string {
+	return fmt.Sprintf("Notebook{Id: %d, UserId: %d, ParentId: %d, Name: %s}",
+		nb.Id.Int, nb.UserId.Int, nb.ParentId.Int, nb.Name.String)
+}
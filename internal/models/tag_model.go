@@ -0,0 +1,19 @@
+package models
+
+import (
+	"fmt"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+type Tag struct {
+	Id        pgtype.Numeric
+	UserId    pgtype.Numeric
+	Name      pgtype.Text
+	NoteCount int
+}
+
+func (t Tag) String() string {
+	return fmt.Sprintf("Tag{Id: %d, UserId: %d, Name: %s, NoteCount: %d}",
+		t.Id.Int, t.UserId.Int, t.Name.String, t.NoteCount)
+}
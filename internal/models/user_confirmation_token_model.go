@@ -1,12 +1,33 @@
 package models
 
-import "github.com/jackc/pgx/v5/pgtype"
+import (
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// Purposes of the tokens mailed to users. A token is only accepted for the
+// purpose it was made for.
+const (
+	TokenPurposeConfirmation  = "confirmation"
+	TokenPurposePasswordReset = "password_reset"
+	TokenPurposeEmailChange   = "email_change"
+)
+
+// TokenLifetimes is how long the tokens of each purpose can be used.
+var TokenLifetimes = map[string]time.Duration{
+	TokenPurposeConfirmation:  48 * time.Hour,
+	TokenPurposePasswordReset: 4 * time.Hour,
+	TokenPurposeEmailChange:   24 * time.Hour,
+}
 
 type UserConfirmationToken struct {
 	Id        pgtype.Numeric
 	UserId    pgtype.Numeric
-	Token     pgtype.Text
+	TokenHash pgtype.Text
+	Purpose   pgtype.Text
 	Confirmed pgtype.Bool
+	ExpiresAt pgtype.Timestamptz
 	CreatedAt pgtype.Date
 	UpdatedAt pgtype.Date
 }
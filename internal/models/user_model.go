@@ -2,17 +2,60 @@ package models
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/jackc/pgx/v5/pgtype"
 )
 
+const DefaultTimezone = "America/Sao_Paulo"
+
+// Timezones are the time zones a user may choose.
+var Timezones = []string{
+	"America/Noronha",
+	"America/Sao_Paulo",
+	"America/Bahia",
+	"America/Fortaleza",
+	"America/Recife",
+	"America/Belem",
+	"America/Manaus",
+	"America/Cuiaba",
+	"America/Porto_Velho",
+	"America/Boa_Vista",
+	"America/Rio_Branco",
+	"Europe/Lisbon",
+	"UTC",
+}
+
+// Location returns the time zone of the given name, the default one when
+// it is empty, falling back to the server time zone when it is unknown.
+func Location(timezone string) *time.Location {
+	if timezone == "" {
+		timezone = DefaultTimezone
+	}
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		return time.Local
+	}
+	return loc
+}
+
 type User struct {
-	Id        pgtype.Numeric
-	Email     pgtype.Text
-	Password  pgtype.Text
-	Active    pgtype.Bool
-	CreatedAt pgtype.Date
-	UpdatedAt pgtype.Date
+	Id           pgtype.Numeric
+	Email        pgtype.Text
+	Password     pgtype.Text
+	Active       pgtype.Bool
+	CreatedAt    pgtype.Date
+	UpdatedAt    pgtype.Date
+	TOTPSecret   pgtype.Text
+	DisplayName  pgtype.Text
+	PendingEmail pgtype.Text
+	Timezone     pgtype.Text
+}
+
+// HasTOTP tells whether the user signs in with a second factor. The
+// secret is only stored once the enrollment is confirmed.
+func (u *User) HasTOTP() bool {
+	return u.TOTPSecret.Valid && u.TOTPSecret.String != ""
 }
 
 func (u *User) String() string {
@@ -0,0 +1,463 @@
+// Package qrcode encodes short texts, such as the provisioning URIs of
+// authenticator apps, as QR codes (ISO/IEC 18004) rendered to SVG. Only
+// the byte mode and the medium error correction level are supported, with
+// versions 1 to 10, which hold up to 213 bytes.
+package qrcode
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var ErrTooLong = errors.New("text too long for a qr code")
+
+const maxVersion = 10
+
+// Error correction codewords per block and number of blocks of each
+// version for the medium level, indexed by version.
+var (
+	eccCodewordsPerBlock = [maxVersion + 1]int{-1, 10, 16, 26, 18, 24, 16, 18, 22, 22, 26}
+	numEccBlocks         = [maxVersion + 1]int{-1, 1, 1, 1, 2, 2, 4, 4, 4, 5, 5}
+)
+
+// Code is an encoded QR code, a square of dark and light modules.
+type Code struct {
+	size       int
+	modules    [][]bool
+	isFunction [][]bool
+}
+
+// Encode returns the QR code of text using the smallest version it fits.
+func Encode(text string) (*Code, error) {
+	data := []byte(text)
+
+	version := 1
+	for ; version <= maxVersion; version++ {
+		if 4+countBits(version)+len(data)*8 <= numDataCodewords(version)*8 {
+			break
+		}
+	}
+	if version > maxVersion {
+		return nil, ErrTooLong
+	}
+
+	var bb bitBuffer
+	bb.append(0x4, 4)
+	bb.append(len(data), countBits(version))
+	for _, b := range data {
+		bb.append(int(b), 8)
+	}
+
+	capacity := numDataCodewords(version) * 8
+	bb.append(0, min(4, capacity-len(bb)))
+	bb.append(0, (8-len(bb)%8)%8)
+	for pad := 0xEC; len(bb) < capacity; pad ^= 0xEC ^ 0x11 {
+		bb.append(pad, 8)
+	}
+
+	codewords := make([]byte, len(bb)/8)
+	for i, bit := range bb {
+		if bit {
+			codewords[i>>3] |= 1 << (7 - i&7)
+		}
+	}
+
+	size := version*4 + 17
+	c := &Code{size: size, modules: newGrid(size), isFunction: newGrid(size)}
+	c.drawFunctionPatterns(version)
+	c.drawCodewords(addEccAndInterleave(codewords, version))
+
+	bestMask, minPenalty := 0, -1
+	for mask := 0; mask < 8; mask++ {
+		c.applyMask(mask)
+		c.drawFormatBits(mask)
+		if penalty := c.penalty(); minPenalty < 0 || penalty < minPenalty {
+			bestMask, minPenalty = mask, penalty
+		}
+		c.applyMask(mask)
+	}
+	c.applyMask(bestMask)
+	c.drawFormatBits(bestMask)
+
+	return c, nil
+}
+
+// Size returns the number of modules on each side of the code.
+func (c *Code) Size() int {
+	return c.size
+}
+
+// Dark tells whether the module at column x and row y is dark.
+func (c *Code) Dark(x, y int) bool {
+	return x >= 0 && x < c.size && y >= 0 && y < c.size && c.modules[y][x]
+}
+
+// SVG renders the code with a quiet zone of border modules around it.
+func (c *Code) SVG(border int) string {
+	var path strings.Builder
+	for y := 0; y < c.size; y++ {
+		for x := 0; x < c.size; x++ {
+			if c.modules[y][x] {
+				fmt.Fprintf(&path, "M%d,%dh1v1h-1z", x+border, y+border)
+			}
+		}
+	}
+
+	dim := c.size + border*2
+	return fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" shape-rendering="crispEdges">`+
+		`<rect width="100%%" height="100%%" fill="#fff"/><path d="%s" fill="#000"/></svg>`, dim, dim, path.String())
+}
+
+func newGrid(size int) [][]bool {
+	grid := make([][]bool, size)
+	for i := range grid {
+		grid[i] = make([]bool, size)
+	}
+	return grid
+}
+
+type bitBuffer []bool
+
+func (bb *bitBuffer) append(value, length int) {
+	for i := length - 1; i >= 0; i-- {
+		*bb = append(*bb, (value>>i)&1 != 0)
+	}
+}
+
+// countBits is the length of the character count of the byte mode.
+func countBits(version int) int {
+	if version < 10 {
+		return 8
+	}
+	return 16
+}
+
+// numRawDataModules is the number of modules left for data and error
+// correction once the function patterns are drawn.
+func numRawDataModules(version int) int {
+	result := (16*version+128)*version + 64
+	if version >= 2 {
+		numAlign := version/7 + 2
+		result -= (25*numAlign-10)*numAlign - 55
+		if version >= 7 {
+			result -= 36
+		}
+	}
+	return result
+}
+
+func numDataCodewords(version int) int {
+	return numRawDataModules(version)/8 - eccCodewordsPerBlock[version]*numEccBlocks[version]
+}
+
+func alignmentPositions(version int) []int {
+	if version == 1 {
+		return nil
+	}
+	numAlign := version/7 + 2
+	step := (version*8 + numAlign*3 + 5) / (numAlign*4 - 4) * 2
+	positions := make([]int, numAlign)
+	positions[0] = 6
+	for i, pos := numAlign-1, version*4+10; i >= 1; i, pos = i-1, pos-step {
+		positions[i] = pos
+	}
+	return positions
+}
+
+func (c *Code) setFunction(x, y int, dark bool) {
+	c.modules[y][x] = dark
+	c.isFunction[y][x] = true
+}
+
+func (c *Code) drawFunctionPatterns(version int) {
+	for i := 0; i < c.size; i++ {
+		c.setFunction(6, i, i%2 == 0)
+		c.setFunction(i, 6, i%2 == 0)
+	}
+
+	c.drawFinderPattern(3, 3)
+	c.drawFinderPattern(c.size-4, 3)
+	c.drawFinderPattern(3, c.size-4)
+
+	positions := alignmentPositions(version)
+	last := len(positions) - 1
+	for i, x := range positions {
+		for j, y := range positions {
+			// skip the corners taken by the finder patterns
+			if (i == 0 && j == 0) || (i == 0 && j == last) || (i == last && j == 0) {
+				continue
+			}
+			c.drawAlignmentPattern(x, y)
+		}
+	}
+
+	// reserve the format areas, drawn again once the mask is chosen
+	c.drawFormatBits(0)
+	c.drawVersion(version)
+}
+
+func (c *Code) drawFinderPattern(x, y int) {
+	for dy := -4; dy <= 4; dy++ {
+		for dx := -4; dx <= 4; dx++ {
+			xx, yy := x+dx, y+dy
+			if xx < 0 || xx >= c.size || yy < 0 || yy >= c.size {
+				continue
+			}
+			dist := max(abs(dx), abs(dy))
+			c.setFunction(xx, yy, dist != 2 && dist != 4)
+		}
+	}
+}
+
+func (c *Code) drawAlignmentPattern(x, y int) {
+	for dy := -2; dy <= 2; dy++ {
+		for dx := -2; dx <= 2; dx++ {
+			c.setFunction(x+dx, y+dy, max(abs(dx), abs(dy)) != 1)
+		}
+	}
+}
+
+// drawFormatBits draws both copies of the error correction level, which
+// is 0 for the medium level, and the mask, protected by a BCH code.
+func (c *Code) drawFormatBits(mask int) {
+	data := mask
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = (rem << 1) ^ ((rem >> 9) * 0x537)
+	}
+	bits := (data<<10 | rem) ^ 0x5412
+	bit := func(i int) bool { return (bits>>i)&1 != 0 }
+
+	for i := 0; i <= 5; i++ {
+		c.setFunction(8, i, bit(i))
+	}
+	c.setFunction(8, 7, bit(6))
+	c.setFunction(8, 8, bit(7))
+	c.setFunction(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		c.setFunction(14-i, 8, bit(i))
+	}
+
+	for i := 0; i < 8; i++ {
+		c.setFunction(c.size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		c.setFunction(8, c.size-15+i, bit(i))
+	}
+	c.setFunction(8, c.size-8, true)
+}
+
+func (c *Code) drawVersion(version int) {
+	if version < 7 {
+		return
+	}
+	rem := version
+	for i := 0; i < 12; i++ {
+		rem = (rem << 1) ^ ((rem >> 11) * 0x1F25)
+	}
+	bits := version<<12 | rem
+
+	for i := 0; i < 18; i++ {
+		dark := (bits>>i)&1 != 0
+		a, b := c.size-11+i%3, i/3
+		c.setFunction(a, b, dark)
+		c.setFunction(b, a, dark)
+	}
+}
+
+// addEccAndInterleave splits the data in blocks, appends the error
+// correction codewords of each block and interleaves them.
+func addEccAndInterleave(data []byte, version int) []byte {
+	numBlocks := numEccBlocks[version]
+	blockEccLen := eccCodewordsPerBlock[version]
+	rawCodewords := numRawDataModules(version) / 8
+	numShortBlocks := numBlocks - rawCodewords%numBlocks
+	shortBlockLen := rawCodewords / numBlocks
+
+	divisor := reedSolomonDivisor(blockEccLen)
+	blocks := make([][]byte, numBlocks)
+	for i, k := 0, 0; i < numBlocks; i++ {
+		datLen := shortBlockLen - blockEccLen
+		if i >= numShortBlocks {
+			datLen++
+		}
+		dat := data[k : k+datLen]
+		k += datLen
+
+		block := append([]byte{}, dat...)
+		if i < numShortBlocks {
+			block = append(block, 0)
+		}
+		blocks[i] = append(block, reedSolomonRemainder(dat, divisor)...)
+	}
+
+	result := make([]byte, 0, rawCodewords)
+	for i := range blocks[0] {
+		for j, block := range blocks {
+			// the padding byte of the short blocks is not part of the code
+			if i != shortBlockLen-blockEccLen || j >= numShortBlocks {
+				result = append(result, block[i])
+			}
+		}
+	}
+	return result
+}
+
+func (c *Code) drawCodewords(data []byte) {
+	i := 0
+	for right := c.size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for vert := 0; vert < c.size; vert++ {
+			for j := 0; j < 2; j++ {
+				x := right - j
+				y := vert
+				if (right+1)&2 == 0 {
+					y = c.size - 1 - vert
+				}
+				if !c.isFunction[y][x] && i < len(data)*8 {
+					c.modules[y][x] = (data[i>>3]>>(7-i&7))&1 != 0
+					i++
+				}
+			}
+		}
+	}
+}
+
+func (c *Code) applyMask(mask int) {
+	for y := 0; y < c.size; y++ {
+		for x := 0; x < c.size; x++ {
+			var invert bool
+			switch mask {
+			case 0:
+				invert = (x+y)%2 == 0
+			case 1:
+				invert = y%2 == 0
+			case 2:
+				invert = x%3 == 0
+			case 3:
+				invert = (x+y)%3 == 0
+			case 4:
+				invert = (x/3+y/2)%2 == 0
+			case 5:
+				invert = x*y%2+x*y%3 == 0
+			case 6:
+				invert = (x*y%2+x*y%3)%2 == 0
+			case 7:
+				invert = ((x+y)%2+x*y%3)%2 == 0
+			}
+			if invert && !c.isFunction[y][x] {
+				c.modules[y][x] = !c.modules[y][x]
+			}
+		}
+	}
+}
+
+// penalty scores how hard the code is to read, so the mask with the
+// lowest score is chosen.
+func (c *Code) penalty() int {
+	result := 0
+	module := func(x, y int, vertical bool) bool {
+		if vertical {
+			return c.modules[x][y]
+		}
+		return c.modules[y][x]
+	}
+
+	for _, vertical := range []bool{false, true} {
+		for y := 0; y < c.size; y++ {
+			run := 0
+			for x := 0; x < c.size; x++ {
+				if x > 0 && module(x, y, vertical) == module(x-1, y, vertical) {
+					run++
+					if run == 5 {
+						result += 3
+					} else if run > 5 {
+						result++
+					}
+				} else {
+					run = 1
+				}
+
+				// patterns like the finders, with four light modules at one side
+				if x+10 < c.size {
+					var line [11]bool
+					for k := range line {
+						line[k] = module(x+k, y, vertical)
+					}
+					if line == [11]bool{true, false, true, true, true, false, true, false, false, false, false} ||
+						line == [11]bool{false, false, false, false, true, false, true, true, true, false, true} {
+						result += 40
+					}
+				}
+			}
+		}
+	}
+
+	dark := 0
+	for y := 0; y < c.size; y++ {
+		for x := 0; x < c.size; x++ {
+			if c.modules[y][x] {
+				dark++
+			}
+			if x > 0 && y > 0 {
+				color := c.modules[y][x]
+				if color == c.modules[y][x-1] && color == c.modules[y-1][x] && color == c.modules[y-1][x-1] {
+					result += 3
+				}
+			}
+		}
+	}
+
+	total := c.size * c.size
+	k := (abs(dark*20-total*10)+total-1)/total - 1
+	return result + k*10
+}
+
+func reedSolomonDivisor(degree int) []byte {
+	result := make([]byte, degree)
+	result[degree-1] = 1
+	root := byte(1)
+	for i := 0; i < degree; i++ {
+		for j := range result {
+			result[j] = gfMultiply(result[j], root)
+			if j+1 < len(result) {
+				result[j] ^= result[j+1]
+			}
+		}
+		root = gfMultiply(root, 0x02)
+	}
+	return result
+}
+
+func reedSolomonRemainder(data, divisor []byte) []byte {
+	result := make([]byte, len(divisor))
+	for _, b := range data {
+		factor := b ^ result[0]
+		copy(result, result[1:])
+		result[len(result)-1] = 0
+		for i, d := range divisor {
+			result[i] ^= gfMultiply(d, factor)
+		}
+	}
+	return result
+}
+
+// gfMultiply multiplies in GF(2^8) modulo x^8 + x^4 + x^3 + x^2 + 1.
+func gfMultiply(x, y byte) byte {
+	z := 0
+	for i := 7; i >= 0; i-- {
+		z = (z << 1) ^ ((z >> 7) * 0x11D)
+		z ^= int((y>>i)&1) * int(x)
+	}
+	return byte(z)
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
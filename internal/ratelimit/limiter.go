@@ -0,0 +1,95 @@
+package ratelimit
+
+import (
+	"context"
+	"time"
+)
+
+// Policy sets how a limiter reacts to failures. The first FreeFailures
+// failures cost nothing, each one after that doubles the wait before the
+// next attempt, from BaseDelay up to MaxDelay, and an attempt after
+// MaxFailures failures locks the key out for Lockout. Failures older than
+// Window are forgotten. Attempts refused for coming too soon count as
+// failures too.
+type Policy struct {
+	FreeFailures int
+	BaseDelay    time.Duration
+	MaxDelay     time.Duration
+	MaxFailures  int
+	Lockout      time.Duration
+	Window       time.Duration
+}
+
+// Limiter applies a policy to the keys of one kind, such as the client
+// addresses or the emails of signins, kept apart in the store by name.
+type Limiter struct {
+	store  Store
+	name   string
+	policy Policy
+}
+
+func NewLimiter(store Store, name string, policy Policy) *Limiter {
+	return &Limiter{store: store, name: name, policy: policy}
+}
+
+func (l *Limiter) key(key string) string {
+	return l.name + ":" + key
+}
+
+// Attempt records an attempt of key before it is checked, so concurrent
+// attempts are all counted, and returns how long key must wait when it is
+// refused, zero when it may go on. It also tells whether this attempt
+// locked the key out, so the owner can be warned once. The caller must
+// call Forgive or Reset when the attempt succeeds.
+func (l *Limiter) Attempt(ctx context.Context, key string) (time.Duration, bool, error) {
+	now := time.Now()
+
+	// attempts on a locked key are not counted, so the lockout ends on time
+	attempts, err := l.store.Get(ctx, l.key(key))
+	if err != nil {
+		return 0, false, err
+	}
+	if attempts.LockedUntil.After(now) {
+		return attempts.LockedUntil.Sub(now), false, nil
+	}
+
+	attempts, err = l.store.Fail(ctx, l.key(key), now, l.policy.Window)
+	if err != nil {
+		return 0, false, err
+	}
+	if attempts.LockedUntil.After(now) {
+		return attempts.LockedUntil.Sub(now), false, nil
+	}
+
+	if l.policy.MaxFailures > 0 && attempts.Failures > l.policy.MaxFailures {
+		if err := l.store.Lock(ctx, l.key(key), now.Add(l.policy.Lockout)); err != nil {
+			return 0, false, err
+		}
+		return l.policy.Lockout, attempts.Failures == l.policy.MaxFailures+1, nil
+	}
+
+	// the failures before this attempt set how long it had to wait
+	extra := attempts.Failures - 1 - l.policy.FreeFailures
+	if extra <= 0 || attempts.PreviousFailure.IsZero() {
+		return 0, false, nil
+	}
+	delay := l.policy.MaxDelay
+	if extra <= 30 {
+		delay = min(l.policy.BaseDelay<<(extra-1), l.policy.MaxDelay)
+	}
+	if next := attempts.PreviousFailure.Add(delay); next.After(now) {
+		return next.Sub(now), false, nil
+	}
+	return 0, false, nil
+}
+
+// Forgive takes back the attempt of key, after it succeeded, without
+// forgetting its other failures.
+func (l *Limiter) Forgive(ctx context.Context, key string) error {
+	return l.store.Forgive(ctx, l.key(key))
+}
+
+// Reset forgets the failures of key, after a successful attempt.
+func (l *Limiter) Reset(ctx context.Context, key string) error {
+	return l.store.Reset(ctx, l.key(key))
+}
@@ -0,0 +1,100 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type memoryEntry struct {
+	Attempts
+	firstFailure time.Time
+}
+
+type memoryStore struct {
+	mu      sync.Mutex
+	entries map[string]*memoryEntry
+}
+
+// NewMemoryStore keeps the attempts in the memory of the process, so they
+// are lost on restart and not shared between instances.
+func NewMemoryStore() Store {
+	return &memoryStore{entries: make(map[string]*memoryEntry)}
+}
+
+func (ms *memoryStore) Get(ctx context.Context, key string) (Attempts, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if entry, ok := ms.entries[key]; ok {
+		return entry.Attempts, nil
+	}
+	return Attempts{}, nil
+}
+
+func (ms *memoryStore) Fail(ctx context.Context, key string, now time.Time, window time.Duration) (Attempts, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	entry, ok := ms.entries[key]
+	if !ok {
+		entry = &memoryEntry{}
+		ms.entries[key] = entry
+	}
+	if entry.Failures == 0 || entry.firstFailure.Before(now.Add(-window)) {
+		entry.Failures = 0
+		entry.firstFailure = now
+	}
+	entry.Failures++
+	attempts := entry.Attempts
+	attempts.PreviousFailure = entry.LastFailure
+	entry.LastFailure = now
+	attempts.LastFailure = now
+	return attempts, nil
+}
+
+func (ms *memoryStore) Forgive(ctx context.Context, key string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if entry, ok := ms.entries[key]; ok && entry.Failures > 0 {
+		entry.Failures--
+	}
+	return nil
+}
+
+func (ms *memoryStore) Lock(ctx context.Context, key string, until time.Time) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	entry, ok := ms.entries[key]
+	if !ok {
+		entry = &memoryEntry{}
+		ms.entries[key] = entry
+	}
+	entry.Failures = 0
+	entry.LockedUntil = until
+	return nil
+}
+
+func (ms *memoryStore) Reset(ctx context.Context, key string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	delete(ms.entries, key)
+	return nil
+}
+
+func (ms *memoryStore) Purge(ctx context.Context, before time.Time) (int, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	count := 0
+	for key, entry := range ms.entries {
+		if entry.LastFailure.Before(before) && entry.LockedUntil.Before(before) {
+			delete(ms.entries, key)
+			count++
+		}
+	}
+	return count, nil
+}
@@ -0,0 +1,114 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type postgresStore struct {
+	db *pgxpool.Pool
+}
+
+// NewPostgresStore keeps the attempts in the login_attempts table, so
+// every instance of the server sees the same counts.
+func NewPostgresStore(dbpool *pgxpool.Pool) Store {
+	return &postgresStore{db: dbpool}
+}
+
+func (ps *postgresStore) Get(ctx context.Context, key string) (Attempts, error) {
+	query := `
+	SELECT failures, last_failure_at, locked_until
+		FROM login_attempts WHERE key = $1`
+
+	attempts, err := scanAttempts(ps.db.QueryRow(ctx, query, key))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Attempts{}, nil
+	}
+	return attempts, err
+}
+
+func (ps *postgresStore) Fail(ctx context.Context, key string, now time.Time, window time.Duration) (Attempts, error) {
+	// the row is locked first, so the previous failure read is the one
+	// this update replaces even under concurrent failures
+	query := `
+	WITH previous AS (
+		SELECT last_failure_at FROM login_attempts WHERE key = $1 FOR UPDATE
+	)
+	INSERT INTO login_attempts (key, failures, first_failure_at, last_failure_at)
+		VALUES ($1, 1, $2, $2)
+		ON CONFLICT (key) DO UPDATE SET
+			failures = CASE
+				WHEN login_attempts.failures = 0 OR login_attempts.first_failure_at < $3 THEN 1
+				ELSE login_attempts.failures + 1 END,
+			first_failure_at = CASE
+				WHEN login_attempts.failures = 0 OR login_attempts.first_failure_at < $3 THEN $2
+				ELSE login_attempts.first_failure_at END,
+			last_failure_at = $2
+		RETURNING failures, last_failure_at, locked_until,
+			(SELECT last_failure_at FROM previous)`
+
+	var attempts Attempts
+	var lastFailure, lockedUntil, previousFailure pgtype.Timestamptz
+	err := ps.db.QueryRow(ctx, query, key, now, now.Add(-window)).
+		Scan(&attempts.Failures, &lastFailure, &lockedUntil, &previousFailure)
+	if err != nil {
+		return Attempts{}, err
+	}
+	attempts.LastFailure = lastFailure.Time
+	attempts.LockedUntil = lockedUntil.Time
+	attempts.PreviousFailure = previousFailure.Time
+	return attempts, nil
+}
+
+func (ps *postgresStore) Forgive(ctx context.Context, key string) error {
+	query := `
+	UPDATE login_attempts SET failures = greatest(failures - 1, 0)
+		WHERE key = $1`
+
+	_, err := ps.db.Exec(ctx, query, key)
+	return err
+}
+
+func (ps *postgresStore) Lock(ctx context.Context, key string, until time.Time) error {
+	query := `
+	INSERT INTO login_attempts (key, failures, locked_until)
+		VALUES ($1, 0, $2)
+		ON CONFLICT (key) DO UPDATE SET failures = 0, locked_until = $2`
+
+	_, err := ps.db.Exec(ctx, query, key, until)
+	return err
+}
+
+func (ps *postgresStore) Reset(ctx context.Context, key string) error {
+	_, err := ps.db.Exec(ctx, `DELETE FROM login_attempts WHERE key = $1`, key)
+	return err
+}
+
+func (ps *postgresStore) Purge(ctx context.Context, before time.Time) (int, error) {
+	query := `
+	DELETE FROM login_attempts
+		WHERE coalesce(last_failure_at, '-infinity') < $1
+		AND coalesce(locked_until, '-infinity') < $1`
+
+	tag, err := ps.db.Exec(ctx, query, before)
+	if err != nil {
+		return 0, err
+	}
+	return int(tag.RowsAffected()), nil
+}
+
+func scanAttempts(row pgx.Row) (Attempts, error) {
+	var attempts Attempts
+	var lastFailure, lockedUntil pgtype.Timestamptz
+	if err := row.Scan(&attempts.Failures, &lastFailure, &lockedUntil); err != nil {
+		return Attempts{}, err
+	}
+	attempts.LastFailure = lastFailure.Time
+	attempts.LockedUntil = lockedUntil.Time
+	return attempts, nil
+}
@@ -0,0 +1,37 @@
+// Package ratelimit slows down and then locks out clients that keep
+// failing, such as signins with a wrong password.
+package ratelimit
+
+import (
+	"context"
+	"time"
+)
+
+// Attempts are the recent failures recorded for a key. PreviousFailure
+// is only set by Fail, to the last failure before the one it recorded.
+type Attempts struct {
+	Failures        int
+	LastFailure     time.Time
+	PreviousFailure time.Time
+	LockedUntil     time.Time
+}
+
+// Store keeps the failures of every key. The memory store is enough for a
+// single instance, the Postgres store shares the counts between instances.
+// Implementations must be safe for concurrent use.
+type Store interface {
+	// Get returns the attempts of key, zero when there are none.
+	Get(ctx context.Context, key string) (Attempts, error)
+	// Fail records a failure at now and returns the attempts of key.
+	// Failures before the start of the window are forgotten. Concurrent
+	// calls for the same key must each see their own count.
+	Fail(ctx context.Context, key string, now time.Time, window time.Duration) (Attempts, error)
+	// Forgive takes back one failure of key.
+	Forgive(ctx context.Context, key string) error
+	// Lock refuses key until the given time and clears its failures.
+	Lock(ctx context.Context, key string, until time.Time) error
+	// Reset forgets key.
+	Reset(ctx context.Context, key string) error
+	// Purge forgets the keys with no failure after before and not locked.
+	Purge(ctx context.Context, before time.Time) (int, error)
+}
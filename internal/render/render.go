@@ -5,19 +5,61 @@ import (
 	"html/template"
 	"log/slog"
 	"net/http"
+	"strconv"
 	"strings"
 
 	"github.com/alexedwards/scs/v2"
 	"github.com/gorilla/csrf"
+	"github.com/rudsonalves/quicknotes/internal/markdown"
+	"github.com/rudsonalves/quicknotes/internal/repositories"
 	"github.com/rudsonalves/quicknotes/views"
 )
 
 type RenderTemplate struct {
-	session *scs.SessionManager
+	session      *scs.SessionManager
+	notebookRepo repositories.NotebookRepository
 }
 
-func NewRender(session *scs.SessionManager) *RenderTemplate {
-	return &RenderTemplate{session: session}
+func NewRender(session *scs.SessionManager, notebookRepo repositories.NotebookRepository) *RenderTemplate {
+	return &RenderTemplate{session: session, notebookRepo: notebookRepo}
+}
+
+// SidebarNotebook is a notebook listed in the sidebar of every page.
+type SidebarNotebook struct {
+	Id        int64
+	Name      string
+	Depth     int
+	NoteCount int
+	Active    bool
+}
+
+// sidebarNotebooks lists the notebooks of the signed in user. Failures are
+// only logged, so the page is still rendered without the sidebar.
+func (rt *RenderTemplate) sidebarNotebooks(r *http.Request) []SidebarNotebook {
+	userId := rt.session.GetInt64(r.Context(), "userId")
+	if userId == 0 {
+		return nil
+	}
+
+	notebooks, err := rt.notebookRepo.List(r.Context(), userId)
+	if err != nil {
+		slog.Error(err.Error())
+		return nil
+	}
+
+	current := r.URL.Query().Get("notebook")
+	var items []SidebarNotebook
+	for _, notebook := range notebooks {
+		id := notebook.Id.Int.Int64()
+		items = append(items, SidebarNotebook{
+			Id:        id,
+			Name:      notebook.Name.String,
+			Depth:     notebook.Depth,
+			NoteCount: notebook.NoteCount,
+			Active:    current == strconv.FormatInt(id, 10),
+		})
+	}
+	return items
 }
 
 func getTemplatePageFiles(t *template.Template, page string, useFS bool) (*template.Template, error) {
@@ -52,6 +94,11 @@ func (rt *RenderTemplate) RenderPage(w http.ResponseWriter, r *http.Request, sta
 		"userEmail": func() string {
 			return rt.session.GetString(r.Context(), "userEmail")
 		},
+		"notebooks": func() []SidebarNotebook {
+			return rt.sidebarNotebooks(r)
+		},
+		"markdown":        markdown.Render,
+		"markdownPreview": markdown.Preview,
 	})
 
 	useFS := !strings.Contains(r.Host, "localhost")
@@ -71,7 +118,17 @@ func (rt *RenderTemplate) RenderPage(w http.ResponseWriter, r *http.Request, sta
 
 func (rt *RenderTemplate) RenderMailBody(r *http.Request, mailTempl string, data map[string]string) ([]byte, error) {
 	useFS := !strings.Contains(r.Host, "localhost")
-	data["hostAddr"] = "https://" + r.Host
+	return renderMail("https://"+r.Host, mailTempl, data, useFS)
+}
+
+// RenderMail renders a mail sent outside of a request, such as by the
+// background jobs, with links pointing to hostAddr.
+func RenderMail(hostAddr, mailTempl string, data map[string]string) ([]byte, error) {
+	return renderMail(hostAddr, mailTempl, data, true)
+}
+
+func renderMail(hostAddr, mailTempl string, data map[string]string, useFS bool) ([]byte, error) {
+	data["hostAddr"] = hostAddr
 	t, err := getTemplateMailFiles(mailTempl, useFS)
 	if err != nil {
 		slog.Error(err.Error())
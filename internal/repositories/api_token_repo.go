@@ -0,0 +1,147 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/rudsonalves/quicknotes/internal/models"
+)
+
+var ErrAPITokenNotFound = newRepositoryError(errors.New("api token not found"))
+
+type APITokenRepository interface {
+	Create(ctx context.Context, userId int64, name, tokenHash string, scopes []string, expiresAt *time.Time) (*models.APIToken, error)
+	List(ctx context.Context, userId int64) ([]models.APIToken, error)
+	Revoke(ctx context.Context, userId, id int64) error
+	FindValidByHash(ctx context.Context, tokenHash string) (*models.APIToken, error)
+	Touch(ctx context.Context, id int64) error
+}
+
+type apiTokenRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewAPITokenRepository(dbpool *pgxpool.Pool) APITokenRepository {
+	return &apiTokenRepository{db: dbpool}
+}
+
+func (tr *apiTokenRepository) Create(ctx context.Context, userId int64, name, tokenHash string, scopes []string, expiresAt *time.Time) (*models.APIToken, error) {
+	var token models.APIToken
+	token.Name = pgtype.Text{String: name, Valid: true}
+	token.TokenHash = pgtype.Text{String: tokenHash, Valid: true}
+	token.Scopes = scopes
+	if expiresAt != nil {
+		token.ExpiresAt = pgtype.Timestamp{Time: *expiresAt, Valid: true}
+	}
+
+	query := `
+	INSERT INTO api_tokens (user_id, name, token_hash, scopes, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, user_id, created_at`
+
+	row := tr.db.QueryRow(ctx, query, userId, token.Name, token.TokenHash, token.Scopes, token.ExpiresAt)
+	if err := row.Scan(&token.Id, &token.UserId, &token.CreatedAt); err != nil {
+		return nil, fail(err)
+	}
+
+	return &token, nil
+}
+
+func (tr *apiTokenRepository) List(ctx context.Context, userId int64) ([]models.APIToken, error) {
+	var tokens []models.APIToken
+	query := `
+	SELECT id, user_id, name, scopes, expires_at, last_used_at, created_at,
+		coalesce(expires_at <= now(), false)
+		FROM api_tokens
+		WHERE user_id = $1
+		ORDER BY created_at DESC`
+
+	rows, err := tr.db.Query(ctx, query, userId)
+	if err != nil {
+		return nil, newRepositoryError(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		token := models.APIToken{}
+		err := rows.Scan(
+			&token.Id,
+			&token.UserId,
+			&token.Name,
+			&token.Scopes,
+			&token.ExpiresAt,
+			&token.LastUsedAt,
+			&token.CreatedAt,
+			&token.Expired)
+		if err != nil {
+			return nil, newRepositoryError(err)
+		}
+
+		tokens = append(tokens, token)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, newRepositoryError(err)
+	}
+
+	return tokens, nil
+}
+
+func (tr *apiTokenRepository) Revoke(ctx context.Context, userId, id int64) error {
+	query := `DELETE FROM api_tokens WHERE id = $1 AND user_id = $2`
+
+	tag, err := tr.db.Exec(ctx, query, id, userId)
+	if err != nil {
+		return newRepositoryError(err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrAPITokenNotFound
+	}
+
+	return nil
+}
+
+// FindValidByHash returns the token with the given hash, as long as it
+// has not expired.
+func (tr *apiTokenRepository) FindValidByHash(ctx context.Context, tokenHash string) (*models.APIToken, error) {
+	var token models.APIToken
+	query := `
+	SELECT t.id, t.user_id, t.name, t.scopes, t.expires_at, t.last_used_at, t.created_at
+		FROM api_tokens t INNER JOIN users u
+		ON u.id = t.user_id
+		WHERE u.active = true
+		AND t.token_hash = $1
+		AND (t.expires_at IS NULL OR t.expires_at > now())`
+
+	row := tr.db.QueryRow(ctx, query, tokenHash)
+	if err := row.Scan(
+		&token.Id,
+		&token.UserId,
+		&token.Name,
+		&token.Scopes,
+		&token.ExpiresAt,
+		&token.LastUsedAt,
+		&token.CreatedAt,
+	); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrAPITokenNotFound
+		}
+		return nil, newRepositoryError(err)
+	}
+
+	return &token, nil
+}
+
+func (tr *apiTokenRepository) Touch(ctx context.Context, id int64) error {
+	query := `UPDATE api_tokens SET last_used_at = now() WHERE id = $1`
+
+	if _, err := tr.db.Exec(ctx, query, id); err != nil {
+		return newRepositoryError(err)
+	}
+
+	return nil
+}
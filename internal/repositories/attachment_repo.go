@@ -0,0 +1,146 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/rudsonalves/quicknotes/internal/models"
+)
+
+var ErrAttachmentNotFound = newRepositoryError(errors.New("attachment not found"))
+
+// AttachmentRepository keeps the metadata of the files attached to notes.
+// The ownerId is the id of the owner of the note, so editors of a shared
+// note act on behalf of the owner.
+type AttachmentRepository interface {
+	Create(ctx context.Context, ownerId, noteId int64, attachment *models.Attachment) error
+	List(ctx context.Context, ownerId, noteId int64) ([]models.Attachment, error)
+	GetById(ctx context.Context, ownerId, noteId, id int64) (*models.Attachment, error)
+	Delete(ctx context.Context, ownerId, noteId, id int64) (*models.Attachment, error)
+	Exists(ctx context.Context, storageKey string) (bool, error)
+}
+
+type attachmentRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewAttachmentRepository(dbpool *pgxpool.Pool) AttachmentRepository {
+	return &attachmentRepository{db: dbpool}
+}
+
+// Create records an attachment already written to the storage, filling
+// its id, note id and creation time.
+func (ar *attachmentRepository) Create(ctx context.Context, ownerId, noteId int64, attachment *models.Attachment) error {
+	query := `
+	INSERT INTO attachments (note_id, storage_key, file_name, content_type, size)
+		SELECT id, $3, $4, $5, $6 FROM notes
+		WHERE id = $1 AND user_id = $2 AND deleted_at IS NULL
+		RETURNING id, note_id, created_at`
+
+	row := ar.db.QueryRow(ctx, query, noteId, ownerId,
+		attachment.StorageKey, attachment.FileName, attachment.ContentType, attachment.Size)
+	if err := row.Scan(&attachment.Id, &attachment.NoteId, &attachment.CreatedAt); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrNoteNotFound
+		}
+		return fail(err)
+	}
+
+	return nil
+}
+
+func (ar *attachmentRepository) List(ctx context.Context, ownerId, noteId int64) ([]models.Attachment, error) {
+	var attachments []models.Attachment
+	query := `
+	SELECT a.id, a.note_id, a.storage_key, a.file_name, a.content_type, a.size, a.created_at
+		FROM attachments a INNER JOIN notes n ON n.id = a.note_id
+		WHERE a.note_id = $1 AND n.user_id = $2
+		ORDER BY a.created_at, a.id`
+
+	rows, err := ar.db.Query(ctx, query, noteId, ownerId)
+	if err != nil {
+		return nil, newRepositoryError(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		attachment, err := scanAttachment(rows)
+		if err != nil {
+			return nil, newRepositoryError(err)
+		}
+		attachments = append(attachments, *attachment)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, newRepositoryError(err)
+	}
+
+	return attachments, nil
+}
+
+func (ar *attachmentRepository) GetById(ctx context.Context, ownerId, noteId, id int64) (*models.Attachment, error) {
+	query := `
+	SELECT a.id, a.note_id, a.storage_key, a.file_name, a.content_type, a.size, a.created_at
+		FROM attachments a INNER JOIN notes n ON n.id = a.note_id
+		WHERE a.id = $1 AND a.note_id = $2 AND n.user_id = $3 AND n.deleted_at IS NULL`
+
+	attachment, err := scanAttachment(ar.db.QueryRow(ctx, query, id, noteId, ownerId))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrAttachmentNotFound
+		}
+		return nil, newRepositoryError(err)
+	}
+
+	return attachment, nil
+}
+
+// Delete removes the attachment record and returns it, so the caller can
+// remove its contents from the storage.
+func (ar *attachmentRepository) Delete(ctx context.Context, ownerId, noteId, id int64) (*models.Attachment, error) {
+	query := `
+	DELETE FROM attachments a
+		USING notes n
+		WHERE a.id = $1 AND a.note_id = $2 AND n.id = a.note_id AND n.user_id = $3
+		RETURNING a.id, a.note_id, a.storage_key, a.file_name, a.content_type, a.size, a.created_at`
+
+	attachment, err := scanAttachment(ar.db.QueryRow(ctx, query, id, noteId, ownerId))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrAttachmentNotFound
+		}
+		return nil, newRepositoryError(err)
+	}
+
+	return attachment, nil
+}
+
+// Exists tells whether a record still points to the stored file, which
+// is how files of notes purged from the trash are found.
+func (ar *attachmentRepository) Exists(ctx context.Context, storageKey string) (bool, error) {
+	var exists bool
+	query := `SELECT EXISTS (SELECT 1 FROM attachments WHERE storage_key = $1)`
+	if err := ar.db.QueryRow(ctx, query, pgtype.Text{String: storageKey, Valid: true}).Scan(&exists); err != nil {
+		return false, newRepositoryError(err)
+	}
+	return exists, nil
+}
+
+func scanAttachment(row pgx.Row) (*models.Attachment, error) {
+	var attachment models.Attachment
+	err := row.Scan(
+		&attachment.Id,
+		&attachment.NoteId,
+		&attachment.StorageKey,
+		&attachment.FileName,
+		&attachment.ContentType,
+		&attachment.Size,
+		&attachment.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &attachment, nil
+}
@@ -0,0 +1,226 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/rudsonalves/quicknotes/internal/models"
+)
+
+var ErrChecklistItemNotFound = newRepositoryError(errors.New("checklist item not found"))
+
+// checklistCountColumns selects how many items of each row of notes are
+// checked and how many there are.
+const checklistCountColumns = `
+	(SELECT count(*) FROM checklist_items ci WHERE ci.note_id = notes.id AND ci.checked),
+	(SELECT count(*) FROM checklist_items ci WHERE ci.note_id = notes.id)`
+
+// ChecklistRepository manages the items of checklist notes. The content
+// of a checklist note mirrors its items as a Markdown task list, so search,
+// export and revisions keep working on it. The ownerId is the id of the
+// owner of the note, so editors of a shared note act on behalf of the owner.
+type ChecklistRepository interface {
+	List(ctx context.Context, ownerId, noteId int64) ([]models.ChecklistItem, error)
+	Toggle(ctx context.Context, ownerId, noteId, id int64) (*models.ChecklistItem, error)
+	Move(ctx context.Context, ownerId, noteId, id int64, position int) error
+}
+
+type checklistRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewChecklistRepository(dbpool *pgxpool.Pool) ChecklistRepository {
+	return &checklistRepository{db: dbpool}
+}
+
+// setNoteKind turns the note into a checklist or back into a text note.
+// The items must then be synced with syncChecklistItems, in the same
+// transaction.
+func setNoteKind(ctx context.Context, tx pgx.Tx, ownerId, noteId int64, kind string) error {
+	query := `
+	UPDATE notes SET kind = $3
+		WHERE id = $1 AND user_id = $2 AND deleted_at IS NULL`
+	tag, err := tx.Exec(ctx, query, noteId, ownerId, kind)
+	if err != nil {
+		return newRepositoryError(err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNoteNotFound
+	}
+	return nil
+}
+
+func (cr *checklistRepository) List(ctx context.Context, ownerId, noteId int64) ([]models.ChecklistItem, error) {
+	query := `
+	SELECT ci.id, ci.note_id, ci.text, ci.checked, ci.position
+		FROM checklist_items ci INNER JOIN notes n ON n.id = ci.note_id
+		WHERE ci.note_id = $1 AND n.user_id = $2 AND n.deleted_at IS NULL
+		ORDER BY ci.position, ci.id`
+
+	return listChecklistItems(ctx, cr.db, query, noteId, ownerId)
+}
+
+// Toggle checks or unchecks the item, returning it with its new state.
+func (cr *checklistRepository) Toggle(ctx context.Context, ownerId, noteId, id int64) (*models.ChecklistItem, error) {
+	tx, err := cr.db.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return nil, fail(err)
+	}
+	defer tx.Rollback(ctx)
+
+	var item models.ChecklistItem
+	query := `
+	UPDATE checklist_items ci SET checked = NOT ci.checked
+		FROM notes n
+		WHERE ci.id = $1 AND ci.note_id = $2 AND n.id = ci.note_id
+		AND n.user_id = $3 AND n.deleted_at IS NULL
+		RETURNING ci.id, ci.note_id, ci.text, ci.checked, ci.position`
+
+	row := tx.QueryRow(ctx, query, id, noteId, ownerId)
+	if err := row.Scan(&item.Id, &item.NoteId, &item.Text, &item.Checked, &item.Position); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrChecklistItemNotFound
+		}
+		return nil, newRepositoryError(err)
+	}
+
+	if err := writeChecklistContent(ctx, tx, noteId); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fail(err)
+	}
+
+	return &item, nil
+}
+
+// Move puts the item at position, counted from zero, shifting the items
+// in between. Positions past the end move the item to the end.
+func (cr *checklistRepository) Move(ctx context.Context, ownerId, noteId, id int64, position int) error {
+	tx, err := cr.db.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return fail(err)
+	}
+	defer tx.Rollback(ctx)
+
+	query := `
+	SELECT ci.id, ci.note_id, ci.text, ci.checked, ci.position
+		FROM checklist_items ci INNER JOIN notes n ON n.id = ci.note_id
+		WHERE ci.note_id = $1 AND n.user_id = $2 AND n.deleted_at IS NULL
+		ORDER BY ci.position, ci.id
+		FOR UPDATE OF ci`
+	items, err := listChecklistItems(ctx, tx, query, noteId, ownerId)
+	if err != nil {
+		return err
+	}
+
+	from := -1
+	for i, item := range items {
+		if item.Id.Int.Int64() == id {
+			from = i
+		}
+	}
+	if from < 0 {
+		return ErrChecklistItemNotFound
+	}
+	position = max(0, min(position, len(items)-1))
+
+	moved := items[from]
+	items = append(items[:from], items[from+1:]...)
+	items = append(items[:position], append([]models.ChecklistItem{moved}, items[position:]...)...)
+
+	for i, item := range items {
+		if _, err := tx.Exec(ctx, `UPDATE checklist_items SET position = $2 WHERE id = $1`, item.Id, i); err != nil {
+			return fail(err)
+		}
+	}
+
+	if err := writeChecklistContent(ctx, tx, noteId); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fail(err)
+	}
+
+	return nil
+}
+
+type checklistQuerier interface {
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+}
+
+func listChecklistItems(ctx context.Context, db checklistQuerier, query string, args ...any) ([]models.ChecklistItem, error) {
+	var items []models.ChecklistItem
+	rows, err := db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, newRepositoryError(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		item := models.ChecklistItem{}
+		if err := rows.Scan(&item.Id, &item.NoteId, &item.Text, &item.Checked, &item.Position); err != nil {
+			return nil, newRepositoryError(err)
+		}
+		items = append(items, item)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, newRepositoryError(err)
+	}
+
+	return items, nil
+}
+
+// syncChecklistItems replaces the items of a checklist note by the ones
+// read from its content, and removes the items of a text note.
+func syncChecklistItems(ctx context.Context, tx pgx.Tx, noteId int64) error {
+	var kind, content string
+	query := `SELECT kind, coalesce(content, '') FROM notes WHERE id = $1`
+	if err := tx.QueryRow(ctx, query, noteId).Scan(&kind, &content); err != nil {
+		return fail(err)
+	}
+
+	if _, err := tx.Exec(ctx, `DELETE FROM checklist_items WHERE note_id = $1`, noteId); err != nil {
+		return fail(err)
+	}
+	if kind != models.NoteKindChecklist {
+		return nil
+	}
+
+	for _, item := range models.ParseChecklist(content) {
+		query := `
+		INSERT INTO checklist_items (note_id, text, checked, position)
+			VALUES ($1, $2, $3, $4)`
+		if _, err := tx.Exec(ctx, query, noteId, item.Text, item.Checked, item.Position); err != nil {
+			return fail(err)
+		}
+	}
+	return nil
+}
+
+// writeChecklistContent writes the items back to the content of the note.
+// The version is increased, so an edit form opened before the change is
+// reported as a conflict, but no revision is kept for a checked item.
+func writeChecklistContent(ctx context.Context, tx pgx.Tx, noteId int64) error {
+	query := `
+	SELECT id, note_id, text, checked, position
+		FROM checklist_items WHERE note_id = $1
+		ORDER BY position, id`
+	items, err := listChecklistItems(ctx, tx, query, noteId)
+	if err != nil {
+		return err
+	}
+
+	query = `
+	UPDATE notes SET content = $2, updated_at = now(), version = version + 1
+		WHERE id = $1`
+	if _, err := tx.Exec(ctx, query, noteId, models.ChecklistMarkdown(items)); err != nil {
+		return fail(err)
+	}
+	return nil
+}
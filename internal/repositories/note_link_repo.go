@@ -0,0 +1,148 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/rudsonalves/quicknotes/internal/models"
+)
+
+var ErrNoteLinkNotFound = newRepositoryError(errors.New("note link not found"))
+
+type NoteLinkRepository interface {
+	Create(ctx context.Context, ownerId, noteId int64, token, passwordHash string, expiresAt *time.Time) (*models.NoteLink, error)
+	List(ctx context.Context, ownerId, noteId int64) ([]models.NoteLink, error)
+	Revoke(ctx context.Context, ownerId, noteId, id int64) error
+	GetNoteByToken(ctx context.Context, token string) (*models.NoteLink, *models.Note, error)
+}
+
+type noteLinkRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewNoteLinkRepository(dbpool *pgxpool.Pool) NoteLinkRepository {
+	return &noteLinkRepository{db: dbpool}
+}
+
+// Create adds a public link to the owner's note. An empty passwordHash
+// leaves the link open and a nil expiresAt makes it never expire.
+func (lr *noteLinkRepository) Create(ctx context.Context, ownerId, noteId int64, token, passwordHash string, expiresAt *time.Time) (*models.NoteLink, error) {
+	var link models.NoteLink
+	link.Token = pgtype.Text{String: token, Valid: true}
+	link.PasswordHash = pgtype.Text{String: passwordHash, Valid: passwordHash != ""}
+	if expiresAt != nil {
+		link.ExpiresAt = pgtype.Timestamp{Time: *expiresAt, Valid: true}
+	}
+
+	query := `
+	INSERT INTO note_links (note_id, token, password_hash, expires_at)
+		SELECT id, $3, $4, $5 FROM notes
+		WHERE id = $1 AND user_id = $2 AND deleted_at IS NULL
+		RETURNING id, note_id, created_at`
+
+	row := lr.db.QueryRow(ctx, query, noteId, ownerId, link.Token, link.PasswordHash, link.ExpiresAt)
+	if err := row.Scan(&link.Id, &link.NoteId, &link.CreatedAt); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNoteNotFound
+		}
+		return nil, fail(err)
+	}
+
+	return &link, nil
+}
+
+// List returns the links of the owner's note that have not expired yet.
+func (lr *noteLinkRepository) List(ctx context.Context, ownerId, noteId int64) ([]models.NoteLink, error) {
+	var links []models.NoteLink
+	query := `
+	SELECT l.id, l.note_id, l.token, l.password_hash, l.expires_at, l.created_at
+		FROM note_links l INNER JOIN notes n ON n.id = l.note_id
+		WHERE l.note_id = $1 AND n.user_id = $2
+		AND (l.expires_at IS NULL OR l.expires_at > now())
+		ORDER BY l.created_at DESC`
+
+	rows, err := lr.db.Query(ctx, query, noteId, ownerId)
+	if err != nil {
+		return nil, newRepositoryError(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		link := models.NoteLink{}
+		err := rows.Scan(
+			&link.Id,
+			&link.NoteId,
+			&link.Token,
+			&link.PasswordHash,
+			&link.ExpiresAt,
+			&link.CreatedAt)
+		if err != nil {
+			return nil, newRepositoryError(err)
+		}
+		links = append(links, link)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, newRepositoryError(err)
+	}
+
+	return links, nil
+}
+
+func (lr *noteLinkRepository) Revoke(ctx context.Context, ownerId, noteId, id int64) error {
+	query := `
+	DELETE FROM note_links l
+		USING notes n
+		WHERE l.id = $1 AND l.note_id = $2 AND n.id = l.note_id AND n.user_id = $3`
+
+	tag, err := lr.db.Exec(ctx, query, id, noteId, ownerId)
+	if err != nil {
+		return newRepositoryError(err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNoteLinkNotFound
+	}
+
+	return nil
+}
+
+// GetNoteByToken returns a valid link and the note it points to. Expired
+// links and notes in the trash are reported as ErrNoteLinkNotFound.
+func (lr *noteLinkRepository) GetNoteByToken(ctx context.Context, token string) (*models.NoteLink, *models.Note, error) {
+	var link models.NoteLink
+	var note models.Note
+	query := `
+	SELECT l.id, l.note_id, l.token, l.password_hash, l.expires_at, l.created_at,
+			notes.id, notes.title, notes.content, notes.color, notes.created_at, notes.updated_at, ` + noteTagsColumn + `
+		FROM note_links l INNER JOIN notes ON notes.id = l.note_id
+		WHERE l.token = $1 AND notes.deleted_at IS NULL
+		AND (l.expires_at IS NULL OR l.expires_at > now())`
+
+	row := lr.db.QueryRow(ctx, query, token)
+	if err := row.Scan(
+		&link.Id,
+		&link.NoteId,
+		&link.Token,
+		&link.PasswordHash,
+		&link.ExpiresAt,
+		&link.CreatedAt,
+		&note.Id,
+		&note.Title,
+		&note.Content,
+		&note.Color,
+		&note.CreatedAt,
+		&note.UpdatedAt,
+		&note.Tags,
+	); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil, ErrNoteLinkNotFound
+		}
+		return nil, nil, newRepositoryError(err)
+	}
+
+	return &link, &note, nil
+}
@@ -2,22 +2,110 @@ package repositories
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"math/big"
+	"slices"
 	"time"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgtype"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/rudsonalves/quicknotes/internal/models"
 )
 
+var ErrNoteNotFound = newRepositoryError(errors.New("note not found"))
+var ErrRevisionNotFound = newRepositoryError(errors.New("note revision not found"))
+var ErrNoteConflict = newRepositoryError(errors.New("note was changed by another update"))
+
+const (
+	DefaultNotePageSize = 20
+	MaxNotePageSize     = 100
+)
+
+const (
+	NoteSortUpdated = "updated"
+	NoteSortCreated = "created"
+	NoteSortTitle   = "title"
+	NoteSortColor   = "color"
+)
+
+// noteSortSpec describes the SQL expression used as a sort key and the
+// type its text representation is cast back to when used as a cursor.
+type noteSortSpec struct {
+	expr string
+	cast string
+	desc bool
+}
+
+var noteSorts = map[string]noteSortSpec{
+	NoteSortUpdated: {expr: "coalesce(updated_at, created_at)", cast: "timestamp", desc: true},
+	NoteSortCreated: {expr: "created_at", cast: "timestamp", desc: true},
+	NoteSortTitle:   {expr: "lower(title)", cast: "text"},
+	NoteSortColor:   {expr: "color", cast: "text"},
+}
+
+func IsValidNoteSort(sort string) bool {
+	_, ok := noteSorts[sort]
+	return ok
+}
+
+// NoteCursor is the position of a note in a sorted list.
+type NoteCursor struct {
+	Pinned bool
+	Key    string
+	Id     int64
+}
+
+// NoteListOptions selects a page of notes. Archived lists the archived
+// notes instead of the active ones and a non-zero Notebook only the notes
+// directly inside that notebook.
+type NoteListOptions struct {
+	Sort     string
+	Limit    int
+	Tag      string
+	Notebook int64
+	Archived bool
+	After    *NoteCursor
+	Before   *NoteCursor
+}
+
+type NotePage struct {
+	Notes []models.Note
+	Next  *NoteCursor
+	Prev  *NoteCursor
+}
+
 type NoteRepository interface {
-	Create(ctx context.Context, userId int64, title, content, color string) (*models.Note, error)
-	GetById(ctx context.Context, id int64) (*models.Note, error)
-	List(ctx context.Context, userId int64) ([]models.Note, error)
-	Update(ctx context.Context, id int64, title, content, color string) (*models.Note, error)
-	Delete(ctx context.Context, id int64) error
+	GetById(ctx context.Context, userId, id int64) (*models.Note, error)
+	List(ctx context.Context, userId int64, opts NoteListOptions) (*NotePage, error)
+	Search(ctx context.Context, userId int64, terms string) ([]models.NoteSearchResult, error)
+	Delete(ctx context.Context, userId, id int64) error
+	Save(ctx context.Context, userId int64, input NoteInput) (*models.Note, error)
+	ListReminders(ctx context.Context, userId int64, limit int) ([]models.Note, error)
+	ClaimDueReminders(ctx context.Context, now time.Time, limit int) ([]models.Reminder, error)
+	ReleaseReminder(ctx context.Context, id int64) error
+	TogglePinned(ctx context.Context, userId, id int64) (*models.Note, error)
+	ToggleArchived(ctx context.Context, userId, id int64) (*models.Note, error)
+	ListTrash(ctx context.Context, userId int64) ([]models.Note, error)
+	Restore(ctx context.Context, userId, id int64) error
+	DeletePermanently(ctx context.Context, userId, id int64) error
+	EmptyTrash(ctx context.Context, userId int64) error
+	PurgeTrash(ctx context.Context, olderThan time.Time) (int64, error)
+	ListRevisions(ctx context.Context, userId, id int64) ([]models.NoteRevision, error)
+	GetRevision(ctx context.Context, userId, id, revisionId int64) (*models.NoteRevision, error)
+	RestoreRevision(ctx context.Context, userId, id, revisionId int64) error
+	ListTags(ctx context.Context, userId int64) ([]models.Tag, error)
+	Import(ctx context.Context, userId int64, notes []models.Note) error
+	Export(ctx context.Context, userId int64, fn func(note *models.Note) error) error
 }
 
+// noteTagsColumn selects the sorted tag names of each row of notes.
+const noteTagsColumn = `coalesce((
+		SELECT array_agg(t.name ORDER BY t.name)
+			FROM note_tags nt INNER JOIN tags t ON t.id = nt.tag_id
+			WHERE nt.note_id = notes.id), '{}')`
+
 type noteRepository struct {
 	db *pgxpool.Pool
 }
@@ -26,18 +114,29 @@ func NewNoteRepository(dbpool *pgxpool.Pool) NoteRepository {
 	return &noteRepository{db: dbpool}
 }
 
-func (nr *noteRepository) Delete(ctx context.Context, id int64) error {
-	query := `DELETE FROM notes WHERE id = $1`
+// Delete moves the note to the trash. It is permanently removed by
+// DeletePermanently or by PurgeTrash after the retention period.
+func (nr *noteRepository) Delete(ctx context.Context, userId, id int64) error {
+	query := `
+	UPDATE notes SET deleted_at = now()
+		WHERE id = $1 AND user_id = $2 AND deleted_at IS NULL`
 
-	_, err := nr.db.Exec(ctx, query, id)
+	tag, err := nr.db.Exec(ctx, query, id, userId)
 	if err != nil {
 		return newRepositoryError(err)
 	}
+	if tag.RowsAffected() == 0 {
+		return ErrNoteNotFound
+	}
 
 	return nil
 }
 
-func (nr *noteRepository) Update(ctx context.Context, id int64, title, content, color string) (*models.Note, error) {
+// updateNote changes the title, content and color of the note, keeping
+// the ones left empty, when its stored version still matches version. It
+// fails with ErrNoteConflict otherwise. The checklist items and the
+// revision are left to the caller.
+func (nr *noteRepository) updateNote(ctx context.Context, tx pgx.Tx, userId, id int64, version int32, title, content, color string) (*models.Note, error) {
 	var note models.Note
 	note.Id = pgtype.Numeric{Int: big.NewInt(id), Valid: true}
 
@@ -54,69 +153,347 @@ func (nr *noteRepository) Update(ctx context.Context, id int64, title, content,
 	if color == "" {
 		newColor = nil
 	}
-	note.UpdatedAt = pgtype.Date{Time: time.Now(), Valid: true}
+	note.UpdatedAt = pgtype.Timestamp{Time: time.Now(), Valid: true}
 
 	query := `
 	UPDATE notes
 		SET title = COALESCE($1, title),
 				content = COALESCE($2, content),
 				color = COALESCE($3, color),
-				updated_at = $4
-		WHERE id = $5`
-	_, err := nr.db.Exec(ctx, query,
-		newTitle, newContent, newColor, note.UpdatedAt.Time, id)
-	if err != nil {
+				updated_at = $4,
+				version = version + 1
+		WHERE id = $5 AND user_id = $6 AND deleted_at IS NULL
+		AND version = $7
+		RETURNING version`
+	row := tx.QueryRow(ctx, query,
+		newTitle, newContent, newColor, note.UpdatedAt.Time, id, userId, version)
+	if err := row.Scan(&note.Version); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nr.updateFailure(ctx, userId, id)
+		}
 		return nil, newRepositoryError(err)
 	}
 
 	return &note, nil
 }
 
-func (nr *noteRepository) Create(ctx context.Context, userId int64, title, content, color string) (*models.Note, error) {
-	var note models.Note
-	note.Title = pgtype.Text{String: title, Valid: true}
-	note.Content = pgtype.Text{String: content, Valid: true}
-	note.Color = pgtype.Text{String: color, Valid: true}
-	query := `
-	INSERT INTO notes (user_id, title, content, color)
-		VALUES ($1, $2, $3, $4)
-		RETURNING id, created_at`
+// NoteInput is a note as saved from its form or the API, a zero Id
+// creating it. An empty Kind and nil Tags keep the ones the note has. The
+// notebook and the reminder are only saved when OwnerFields is set, since
+// editors save shared notes on behalf of their owner and the API does not
+// send them.
+type NoteInput struct {
+	Id          int64
+	Version     int32
+	Title       string
+	Content     string
+	Color       string
+	Kind        string
+	Tags        []string
+	OwnerFields bool
+	NotebookId  *int64
+	RemindAt    *time.Time
+}
 
-	row := nr.db.QueryRow(ctx, query, userId, title, content, color)
-	if err := row.Scan(&note.Id, &note.CreatedAt); err != nil {
-		return nil, newRepositoryError(err)
+// Save creates or updates the note together with its kind, tags, notebook
+// and reminder in a single transaction, so a failure in any of them
+// leaves the note as it was. Updates fail with ErrNoteConflict when the
+// note was changed since input.Version.
+func (nr *noteRepository) Save(ctx context.Context, userId int64, input NoteInput) (*models.Note, error) {
+	tx, err := nr.db.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return nil, fail(err)
 	}
+	defer tx.Rollback(ctx)
 
-	return &note, nil
+	var note *models.Note
+	if input.Id > 0 {
+		note, err = nr.updateNote(ctx, tx, userId, input.Id, input.Version, input.Title, input.Content, input.Color)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		note = &models.Note{
+			Title:   pgtype.Text{String: input.Title, Valid: true},
+			Content: pgtype.Text{String: input.Content, Valid: true},
+			Color:   pgtype.Text{String: input.Color, Valid: true},
+		}
+		if err := nr.createNote(ctx, tx, userId, note); err != nil {
+			return nil, err
+		}
+	}
+	id := note.Id.Int.Int64()
+
+	if input.Kind != "" {
+		if err := setNoteKind(ctx, tx, userId, id, input.Kind); err != nil {
+			return nil, err
+		}
+	}
+	if err := syncChecklistItems(ctx, tx, id); err != nil {
+		return nil, err
+	}
+	// a new note got its first revision when it was created
+	if input.Id > 0 {
+		if err := nr.createRevision(ctx, tx, id); err != nil {
+			return nil, err
+		}
+	}
+
+	if input.Tags != nil {
+		if err := nr.replaceTags(ctx, tx, userId, id, input.Tags); err != nil {
+			return nil, err
+		}
+	}
+
+	if input.OwnerFields {
+		if err := moveToNotebook(ctx, tx, userId, id, input.NotebookId); err != nil {
+			return nil, err
+		}
+		if err := setReminder(ctx, tx, userId, id, input.RemindAt); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fail(err)
+	}
+
+	return note, nil
 }
 
-func (nr *noteRepository) List(ctx context.Context, userId int64) ([]models.Note, error) {
-	var notes []models.Note
+// updateFailure tells whether an update matched no rows because the note
+// does not exist or because its version changed.
+func (nr *noteRepository) updateFailure(ctx context.Context, userId, id int64) error {
+	var exists bool
+	query := `SELECT EXISTS (SELECT 1 FROM notes WHERE id = $1 AND user_id = $2 AND deleted_at IS NULL)`
+	if err := nr.db.QueryRow(ctx, query, id, userId).Scan(&exists); err != nil {
+		return newRepositoryError(err)
+	}
+	if exists {
+		return ErrNoteConflict
+	}
+	return ErrNoteNotFound
+}
+
+// Import creates the notes with their flags, timestamps and tags in a
+// single transaction, so either all of them or none are saved.
+func (nr *noteRepository) Import(ctx context.Context, userId int64, notes []models.Note) error {
+	tx, err := nr.db.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return fail(err)
+	}
+	defer tx.Rollback(ctx)
+
+	for i := range notes {
+		if err := nr.createNote(ctx, tx, userId, &notes[i]); err != nil {
+			return err
+		}
+		if len(notes[i].Tags) > 0 {
+			if err := nr.setTags(ctx, tx, userId, notes[i].Id.Int.Int64(), notes[i].Tags); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fail(err)
+	}
+
+	return nil
+}
+
+// createNote inserts the note and its first revision, filling its id and
+// version. Missing creation times default to now.
+func (nr *noteRepository) createNote(ctx context.Context, tx pgx.Tx, userId int64, note *models.Note) error {
 	query := `
-	SELECT id, user_id, title, content, color, created_at, updated_at
+	INSERT INTO notes (user_id, title, content, color, pinned, archived, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, coalesce($7, CURRENT_TIMESTAMP), $8)
+		RETURNING id, version, created_at`
+
+	row := tx.QueryRow(ctx, query, userId, note.Title, note.Content, note.Color,
+		note.Pinned.Bool, note.Archived.Bool, note.CreatedAt, note.UpdatedAt)
+	if err := row.Scan(&note.Id, &note.Version, &note.CreatedAt); err != nil {
+		return newRepositoryError(err)
+	}
+
+	return nr.createRevision(ctx, tx, note.Id.Int.Int64())
+}
+
+// List returns a page of the user's notes, pinned notes first. Pages are
+// fetched by keyset pagination, comparing the pinned flag, sort key and id
+// of the notes against the cursor given in opts.After or opts.Before.
+func (nr *noteRepository) List(ctx context.Context, userId int64, opts NoteListOptions) (*NotePage, error) {
+	spec, ok := noteSorts[opts.Sort]
+	if !ok {
+		spec = noteSorts[NoteSortUpdated]
+	}
+	limit := opts.Limit
+	if limit <= 0 {
+		limit = DefaultNotePageSize
+	}
+
+	// walking backward the order is reversed and the page flipped back later
+	backward := opts.Before != nil
+	cursor := opts.After
+	if backward {
+		cursor = opts.Before
+	}
+	cmp, order := ">", "ASC"
+	if spec.desc != backward {
+		cmp, order = "<", "DESC"
+	}
+	// pinned notes come first whatever the direction of the sort key
+	pinnedExpr := "NOT pinned"
+	if spec.desc {
+		pinnedExpr = "pinned"
+	}
+
+	args := []any{userId, opts.Archived}
+	where := "user_id = $1 AND deleted_at IS NULL AND archived = $2"
+	if opts.Tag != "" {
+		args = append(args, opts.Tag)
+		where += fmt.Sprintf(` AND EXISTS (
+			SELECT 1 FROM note_tags nt INNER JOIN tags t ON t.id = nt.tag_id
+				WHERE nt.note_id = notes.id AND t.name = $%d)`, len(args))
+	}
+	if opts.Notebook > 0 {
+		args = append(args, opts.Notebook)
+		where += fmt.Sprintf(" AND notebook_id = $%d", len(args))
+	}
+	if cursor != nil {
+		pinnedKey := cursor.Pinned
+		if !spec.desc {
+			pinnedKey = !pinnedKey
+		}
+		args = append(args, pinnedKey, cursor.Key, cursor.Id)
+		where += fmt.Sprintf(" AND (%s, %s, id) %s ($%d::boolean, $%d::%s, $%d)",
+			pinnedExpr, spec.expr, cmp, len(args)-2, len(args)-1, spec.cast, len(args))
+	}
+	args = append(args, limit+1)
+
+	query := fmt.Sprintf(`
+	SELECT id, user_id, title, content, color, kind, version, pinned, archived, created_at, updated_at, %s, %s, (%s)::text
 		FROM notes
-		WHERE user_id = $1`
+		WHERE %s
+		ORDER BY %s %s, %s %s, id %s
+		LIMIT $%d`, noteTagsColumn, checklistCountColumns, spec.expr, where, pinnedExpr, order, spec.expr, order, order, len(args))
 
-	rows, err := nr.db.Query(ctx, query, userId)
+	rows, err := nr.db.Query(ctx, query, args...)
 	if err != nil {
 		return nil, newRepositoryError(err)
 	}
 	defer rows.Close()
 
+	var notes []models.Note
+	var keys []string
+	for rows.Next() {
+		note := models.Note{}
+		var key string
+		err := rows.Scan(
+			&note.Id,
+			&note.UserId,
+			&note.Title,
+			&note.Content,
+			&note.Color,
+			&note.Kind,
+			&note.Version,
+			&note.Pinned,
+			&note.Archived,
+			&note.CreatedAt,
+			&note.UpdatedAt,
+			&note.Tags,
+			&note.ItemsDone,
+			&note.ItemsTotal,
+			&key)
+		if err != nil {
+			return nil, newRepositoryError(err)
+		}
+
+		notes = append(notes, note)
+		keys = append(keys, key)
+	}
+
 	if err := rows.Err(); err != nil {
 		return nil, newRepositoryError(err)
 	}
 
+	hasMore := len(notes) > limit
+	if hasMore {
+		notes = notes[:limit]
+		keys = keys[:limit]
+	}
+	if backward {
+		slices.Reverse(notes)
+		slices.Reverse(keys)
+	}
+
+	page := &NotePage{Notes: notes}
+	if len(notes) == 0 {
+		return page, nil
+	}
+
+	firstNote, lastNote := notes[0], notes[len(notes)-1]
+	first := &NoteCursor{Pinned: firstNote.Pinned.Bool, Key: keys[0], Id: firstNote.Id.Int.Int64()}
+	last := &NoteCursor{Pinned: lastNote.Pinned.Bool, Key: keys[len(keys)-1], Id: lastNote.Id.Int.Int64()}
+	if backward {
+		page.Next = last
+		if hasMore {
+			page.Prev = first
+		}
+	} else {
+		if hasMore {
+			page.Next = last
+		}
+		if opts.After != nil {
+			page.Prev = first
+		}
+	}
+
+	return page, nil
+}
+
+// Search runs a full-text search over title and content, returning the
+// best ranked notes first.
+func (nr *noteRepository) Search(ctx context.Context, userId int64, terms string) ([]models.NoteSearchResult, error) {
+	var notes []models.NoteSearchResult
+	query := `
+	SELECT id, user_id, title, content, color, kind, version, pinned, archived, created_at, updated_at, ` + noteTagsColumn + `,
+			` + checklistCountColumns + `,
+			ts_rank(search, q) AS rank,
+			ts_headline('portuguese', coalesce(content, ''), q,
+				'StartSel=<mark>, StopSel=</mark>, MaxFragments=2, MaxWords=25, MinWords=10') AS snippet
+		FROM notes, websearch_to_tsquery('portuguese', $2) q
+		WHERE user_id = $1
+		AND deleted_at IS NULL
+		AND archived = false
+		AND search @@ q
+		ORDER BY rank DESC, id DESC`
+
+	rows, err := nr.db.Query(ctx, query, userId, terms)
+	if err != nil {
+		return nil, newRepositoryError(err)
+	}
+	defer rows.Close()
+
 	for rows.Next() {
-		note := models.Note{}
+		note := models.NoteSearchResult{}
 		err := rows.Scan(
 			&note.Id,
 			&note.UserId,
 			&note.Title,
 			&note.Content,
 			&note.Color,
+			&note.Kind,
+			&note.Version,
+			&note.Pinned,
+			&note.Archived,
 			&note.CreatedAt,
-			&note.UpdatedAt)
+			&note.UpdatedAt,
+			&note.Tags,
+			&note.ItemsDone,
+			&note.ItemsTotal,
+			&note.Rank,
+			&note.Snippet)
 		if err != nil {
 			return nil, newRepositoryError(err)
 		}
@@ -124,26 +501,539 @@ func (nr *noteRepository) List(ctx context.Context, userId int64) ([]models.Note
 		notes = append(notes, note)
 	}
 
+	if err := rows.Err(); err != nil {
+		return nil, newRepositoryError(err)
+	}
+
 	return notes, nil
 }
 
-func (nr *noteRepository) GetById(ctx context.Context, id int64) (*models.Note, error) {
+func (nr *noteRepository) GetById(ctx context.Context, userId, id int64) (*models.Note, error) {
 	var note models.Note
 	query := `
-	SELECT id, user_id, title, content, color, created_at, updated_at
+	SELECT id, user_id, title, content, color, kind, notebook_id, version, pinned, archived, remind_at, created_at, updated_at, ` + noteTagsColumn + `
 		FROM notes
-		WHERE id = $1`
+		WHERE id = $1 AND user_id = $2 AND deleted_at IS NULL`
 
-	row := nr.db.QueryRow(ctx, query, id)
+	row := nr.db.QueryRow(ctx, query, id, userId)
 	if err := row.Scan(
 		&note.Id,
 		&note.UserId,
 		&note.Title,
 		&note.Content,
 		&note.Color,
+		&note.Kind,
+		&note.NotebookId,
+		&note.Version,
+		&note.Pinned,
+		&note.Archived,
+		&note.RemindAt,
 		&note.CreatedAt,
 		&note.UpdatedAt,
+		&note.Tags,
+	); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNoteNotFound
+		}
+		return nil, newRepositoryError(err)
+	}
+
+	return &note, nil
+}
+
+// replaceTags replaces the tags of a note, creating the missing ones and
+// removing tags no longer used by any note of the user.
+func (nr *noteRepository) replaceTags(ctx context.Context, tx pgx.Tx, userId, id int64, tags []string) error {
+	var noteId int64
+	query := `SELECT id FROM notes WHERE id = $1 AND user_id = $2 AND deleted_at IS NULL FOR UPDATE`
+	if err := tx.QueryRow(ctx, query, id, userId).Scan(&noteId); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrNoteNotFound
+		}
+		return fail(err)
+	}
+
+	if err := nr.setTags(ctx, tx, userId, noteId, tags); err != nil {
+		return err
+	}
+
+	query = `
+	DELETE FROM tags t
+		WHERE t.user_id = $1
+		AND NOT EXISTS (SELECT 1 FROM note_tags nt WHERE nt.tag_id = t.id)`
+	if _, err := tx.Exec(ctx, query, userId); err != nil {
+		return fail(err)
+	}
+
+	return nil
+}
+
+// setTags links the note to the tags, creating the missing ones, in place
+// of the tags it had before.
+func (nr *noteRepository) setTags(ctx context.Context, tx pgx.Tx, userId, noteId int64, tags []string) error {
+	query := `
+	INSERT INTO tags (user_id, name)
+		SELECT $1, unnest($2::text[])
+		ON CONFLICT (user_id, name) DO NOTHING`
+	if _, err := tx.Exec(ctx, query, userId, tags); err != nil {
+		return fail(err)
+	}
+
+	query = `DELETE FROM note_tags WHERE note_id = $1`
+	if _, err := tx.Exec(ctx, query, noteId); err != nil {
+		return fail(err)
+	}
+
+	query = `
+	INSERT INTO note_tags (note_id, tag_id)
+		SELECT $1, id FROM tags
+		WHERE user_id = $2 AND name = ANY($3)`
+	if _, err := tx.Exec(ctx, query, noteId, userId, tags); err != nil {
+		return fail(err)
+	}
+
+	return nil
+}
+
+// ListTags returns the user's tags with the number of notes using them.
+func (nr *noteRepository) ListTags(ctx context.Context, userId int64) ([]models.Tag, error) {
+	var tags []models.Tag
+	query := `
+	SELECT t.id, t.user_id, t.name, count(nt.note_id)
+		FROM tags t INNER JOIN note_tags nt ON nt.tag_id = t.id
+		INNER JOIN notes n ON n.id = nt.note_id
+		WHERE t.user_id = $1 AND n.deleted_at IS NULL
+		GROUP BY t.id
+		ORDER BY t.name`
+
+	rows, err := nr.db.Query(ctx, query, userId)
+	if err != nil {
+		return nil, newRepositoryError(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		tag := models.Tag{}
+		if err := rows.Scan(&tag.Id, &tag.UserId, &tag.Name, &tag.NoteCount); err != nil {
+			return nil, newRepositoryError(err)
+		}
+		tags = append(tags, tag)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, newRepositoryError(err)
+	}
+
+	return tags, nil
+}
+
+// Export calls fn with each of the user's notes not in the trash, oldest
+// first. Rows are read one at a time, so the notes are never all held in
+// memory. An error returned by fn stops the export and is returned as is.
+func (nr *noteRepository) Export(ctx context.Context, userId int64, fn func(note *models.Note) error) error {
+	query := `
+	SELECT id, user_id, title, content, color, version, pinned, archived, created_at, updated_at, ` + noteTagsColumn + `
+		FROM notes
+		WHERE user_id = $1 AND deleted_at IS NULL
+		ORDER BY id`
+
+	rows, err := nr.db.Query(ctx, query, userId)
+	if err != nil {
+		return newRepositoryError(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		note := models.Note{}
+		err := rows.Scan(
+			&note.Id,
+			&note.UserId,
+			&note.Title,
+			&note.Content,
+			&note.Color,
+			&note.Version,
+			&note.Pinned,
+			&note.Archived,
+			&note.CreatedAt,
+			&note.UpdatedAt,
+			&note.Tags)
+		if err != nil {
+			return newRepositoryError(err)
+		}
+		if err := fn(&note); err != nil {
+			return err
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return newRepositoryError(err)
+	}
+
+	return nil
+}
+
+// createRevision stores the current state of the note as a new revision.
+// It must run in the same transaction that changed the note.
+func (nr *noteRepository) createRevision(ctx context.Context, tx pgx.Tx, id int64) error {
+	query := `
+	INSERT INTO note_revisions (note_id, title, content, color)
+		SELECT id, title, content, color FROM notes WHERE id = $1`
+	if _, err := tx.Exec(ctx, query, id); err != nil {
+		return fail(err)
+	}
+	return nil
+}
+
+// ListRevisions returns the revisions of a note, newest first.
+func (nr *noteRepository) ListRevisions(ctx context.Context, userId, id int64) ([]models.NoteRevision, error) {
+	var revisions []models.NoteRevision
+	query := `
+	SELECT r.id, r.note_id, r.title, r.content, r.color, r.created_at
+		FROM note_revisions r INNER JOIN notes n ON n.id = r.note_id
+		WHERE r.note_id = $1 AND n.user_id = $2 AND n.deleted_at IS NULL
+		ORDER BY r.id DESC`
+
+	rows, err := nr.db.Query(ctx, query, id, userId)
+	if err != nil {
+		return nil, newRepositoryError(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		revision := models.NoteRevision{}
+		err := rows.Scan(
+			&revision.Id,
+			&revision.NoteId,
+			&revision.Title,
+			&revision.Content,
+			&revision.Color,
+			&revision.CreatedAt)
+		if err != nil {
+			return nil, newRepositoryError(err)
+		}
+		revisions = append(revisions, revision)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, newRepositoryError(err)
+	}
+
+	return revisions, nil
+}
+
+func (nr *noteRepository) GetRevision(ctx context.Context, userId, id, revisionId int64) (*models.NoteRevision, error) {
+	var revision models.NoteRevision
+	query := `
+	SELECT r.id, r.note_id, r.title, r.content, r.color, r.created_at
+		FROM note_revisions r INNER JOIN notes n ON n.id = r.note_id
+		WHERE r.id = $1 AND r.note_id = $2 AND n.user_id = $3 AND n.deleted_at IS NULL`
+
+	row := nr.db.QueryRow(ctx, query, revisionId, id, userId)
+	if err := row.Scan(
+		&revision.Id,
+		&revision.NoteId,
+		&revision.Title,
+		&revision.Content,
+		&revision.Color,
+		&revision.CreatedAt,
 	); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrRevisionNotFound
+		}
+		return nil, newRepositoryError(err)
+	}
+
+	return &revision, nil
+}
+
+// RestoreRevision copies the revision back into the note whatever its
+// current version, since the user picked the revision from the history.
+// The restore is recorded as a new revision, so it can be undone as well.
+func (nr *noteRepository) RestoreRevision(ctx context.Context, userId, id, revisionId int64) error {
+	tx, err := nr.db.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return fail(err)
+	}
+	defer tx.Rollback(ctx)
+
+	query := `
+	UPDATE notes n
+		SET title = r.title,
+				content = coalesce(nullif(r.content, ''), n.content),
+				color = r.color,
+				updated_at = now(),
+				version = n.version + 1
+		FROM note_revisions r
+		WHERE r.id = $1 AND r.note_id = n.id
+		AND n.id = $2 AND n.user_id = $3 AND n.deleted_at IS NULL`
+	tag, err := tx.Exec(ctx, query, revisionId, id, userId)
+	if err != nil {
+		return fail(err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrRevisionNotFound
+	}
+
+	if err := syncChecklistItems(ctx, tx, id); err != nil {
+		return err
+	}
+	if err := nr.createRevision(ctx, tx, id); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fail(err)
+	}
+
+	return nil
+}
+
+// ListTrash returns the notes moved to the trash, most recently deleted
+// first.
+func (nr *noteRepository) ListTrash(ctx context.Context, userId int64) ([]models.Note, error) {
+	var notes []models.Note
+	query := `
+	SELECT id, user_id, title, content, color, created_at, updated_at, deleted_at, ` + noteTagsColumn + `
+		FROM notes
+		WHERE user_id = $1 AND deleted_at IS NOT NULL
+		ORDER BY deleted_at DESC, id DESC`
+
+	rows, err := nr.db.Query(ctx, query, userId)
+	if err != nil {
+		return nil, newRepositoryError(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		note := models.Note{}
+		err := rows.Scan(
+			&note.Id,
+			&note.UserId,
+			&note.Title,
+			&note.Content,
+			&note.Color,
+			&note.CreatedAt,
+			&note.UpdatedAt,
+			&note.DeletedAt,
+			&note.Tags)
+		if err != nil {
+			return nil, newRepositoryError(err)
+		}
+		notes = append(notes, note)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, newRepositoryError(err)
+	}
+
+	return notes, nil
+}
+
+// Restore takes a note out of the trash.
+func (nr *noteRepository) Restore(ctx context.Context, userId, id int64) error {
+	query := `
+	UPDATE notes SET deleted_at = NULL
+		WHERE id = $1 AND user_id = $2 AND deleted_at IS NOT NULL`
+
+	tag, err := nr.db.Exec(ctx, query, id, userId)
+	if err != nil {
+		return newRepositoryError(err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNoteNotFound
+	}
+
+	return nil
+}
+
+// DeletePermanently removes a note that is already in the trash.
+func (nr *noteRepository) DeletePermanently(ctx context.Context, userId, id int64) error {
+	query := `DELETE FROM notes WHERE id = $1 AND user_id = $2 AND deleted_at IS NOT NULL`
+
+	tag, err := nr.db.Exec(ctx, query, id, userId)
+	if err != nil {
+		return newRepositoryError(err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNoteNotFound
+	}
+
+	return nil
+}
+
+func (nr *noteRepository) EmptyTrash(ctx context.Context, userId int64) error {
+	query := `DELETE FROM notes WHERE user_id = $1 AND deleted_at IS NOT NULL`
+
+	if _, err := nr.db.Exec(ctx, query, userId); err != nil {
+		return newRepositoryError(err)
+	}
+
+	return nil
+}
+
+// PurgeTrash permanently removes the notes of every user moved to the
+// trash before olderThan, returning how many were removed.
+func (nr *noteRepository) PurgeTrash(ctx context.Context, olderThan time.Time) (int64, error) {
+	query := `DELETE FROM notes WHERE deleted_at IS NOT NULL AND deleted_at < $1`
+
+	tag, err := nr.db.Exec(ctx, query, olderThan)
+	if err != nil {
+		return 0, fail(err)
+	}
+
+	return tag.RowsAffected(), nil
+}
+
+// moveToNotebook puts the note inside one of the user's notebooks, or
+// takes it out of any notebook when notebookId is nil.
+func moveToNotebook(ctx context.Context, tx pgx.Tx, userId, id int64, notebookId *int64) error {
+	query := `
+	UPDATE notes SET notebook_id = $3
+		WHERE id = $1 AND user_id = $2 AND deleted_at IS NULL
+		AND ($3::bigint IS NULL OR EXISTS (SELECT 1 FROM notebooks WHERE id = $3 AND user_id = $2))`
+
+	tag, err := tx.Exec(ctx, query, id, userId, notebookId)
+	if err != nil {
+		return newRepositoryError(err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNoteNotFound
+	}
+
+	return nil
+}
+
+// setReminder schedules a reminder for the note, or removes it when
+// remindAt is nil. A reminder moved to another time is sent again.
+func setReminder(ctx context.Context, tx pgx.Tx, userId, id int64, remindAt *time.Time) error {
+	query := `
+	UPDATE notes SET remind_at = $3,
+		reminder_sent_at = CASE WHEN remind_at IS NOT DISTINCT FROM $3 THEN reminder_sent_at END
+		WHERE id = $1 AND user_id = $2 AND deleted_at IS NULL`
+
+	tag, err := tx.Exec(ctx, query, id, userId, remindAt)
+	if err != nil {
+		return newRepositoryError(err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNoteNotFound
+	}
+
+	return nil
+}
+
+// ListReminders returns the notes of the user with a reminder not sent
+// yet, the closest first.
+func (nr *noteRepository) ListReminders(ctx context.Context, userId int64, limit int) ([]models.Note, error) {
+	var notes []models.Note
+	query := `
+	SELECT id, title, color, remind_at
+		FROM notes
+		WHERE user_id = $1 AND deleted_at IS NULL
+		AND remind_at IS NOT NULL AND reminder_sent_at IS NULL
+		ORDER BY remind_at, id
+		LIMIT $2`
+
+	rows, err := nr.db.Query(ctx, query, userId, limit)
+	if err != nil {
+		return nil, newRepositoryError(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		note := models.Note{}
+		if err := rows.Scan(&note.Id, &note.Title, &note.Color, &note.RemindAt); err != nil {
+			return nil, newRepositoryError(err)
+		}
+		notes = append(notes, note)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, newRepositoryError(err)
+	}
+
+	return notes, nil
+}
+
+// ClaimDueReminders marks the reminders due at now as sent and returns
+// them. Marking them before sending means a restart never sends a
+// reminder twice, and concurrent servers never claim the same one.
+func (nr *noteRepository) ClaimDueReminders(ctx context.Context, now time.Time, limit int) ([]models.Reminder, error) {
+	var reminders []models.Reminder
+	query := `
+	UPDATE notes SET reminder_sent_at = $1
+		FROM users
+		WHERE users.id = notes.user_id AND notes.id IN (
+			SELECT id FROM notes
+				WHERE remind_at <= $1 AND reminder_sent_at IS NULL AND deleted_at IS NULL
+				ORDER BY remind_at
+				LIMIT $2
+				FOR UPDATE SKIP LOCKED)
+		RETURNING notes.id, notes.title, notes.content, notes.remind_at, users.email, users.timezone`
+
+	rows, err := nr.db.Query(ctx, query, now, limit)
+	if err != nil {
+		return nil, fail(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		reminder := models.Reminder{}
+		err := rows.Scan(
+			&reminder.NoteId,
+			&reminder.Title,
+			&reminder.Content,
+			&reminder.RemindAt,
+			&reminder.Email,
+			&reminder.Timezone)
+		if err != nil {
+			return nil, fail(err)
+		}
+		reminders = append(reminders, reminder)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fail(err)
+	}
+
+	return reminders, nil
+}
+
+// ReleaseReminder marks a claimed reminder as not sent, so it is claimed
+// again when sending it failed.
+func (nr *noteRepository) ReleaseReminder(ctx context.Context, id int64) error {
+	query := `UPDATE notes SET reminder_sent_at = NULL WHERE id = $1`
+
+	if _, err := nr.db.Exec(ctx, query, id); err != nil {
+		return fail(err)
+	}
+
+	return nil
+}
+
+// TogglePinned pins or unpins the note.
+func (nr *noteRepository) TogglePinned(ctx context.Context, userId, id int64) (*models.Note, error) {
+	return nr.toggleFlag(ctx, userId, id, "pinned")
+}
+
+// ToggleArchived moves the note to or out of the archive.
+func (nr *noteRepository) ToggleArchived(ctx context.Context, userId, id int64) (*models.Note, error) {
+	return nr.toggleFlag(ctx, userId, id, "archived")
+}
+
+func (nr *noteRepository) toggleFlag(ctx context.Context, userId, id int64, column string) (*models.Note, error) {
+	var note models.Note
+	note.Id = pgtype.Numeric{Int: big.NewInt(id), Valid: true}
+	query := fmt.Sprintf(`
+	UPDATE notes SET %s = NOT %s
+		WHERE id = $1 AND user_id = $2 AND deleted_at IS NULL
+		RETURNING pinned, archived`, column, column)
+
+	row := nr.db.QueryRow(ctx, query, id, userId)
+	if err := row.Scan(&note.Pinned, &note.Archived); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNoteNotFound
+		}
 		return nil, newRepositoryError(err)
 	}
 
@@ -0,0 +1,262 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/rudsonalves/quicknotes/internal/models"
+)
+
+var ErrShareNotFound = newRepositoryError(errors.New("note share not found"))
+var ErrShareWithOwner = newRepositoryError(errors.New("note cannot be shared with its owner"))
+
+type NoteShareRepository interface {
+	Share(ctx context.Context, ownerId, noteId int64, email, role string) (*models.NoteShare, error)
+	List(ctx context.Context, ownerId, noteId int64) ([]models.NoteShare, error)
+	Unshare(ctx context.Context, ownerId, noteId, id int64) error
+	GetSharedNote(ctx context.Context, userId, noteId int64) (*models.SharedNote, error)
+	ListSharedWithMe(ctx context.Context, userId int64) ([]models.SharedNote, error)
+}
+
+type noteShareRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewNoteShareRepository(dbpool *pgxpool.Pool) NoteShareRepository {
+	return &noteShareRepository{db: dbpool}
+}
+
+// Share grants the user registered with email access to the owner's note.
+// When no account uses the email yet, the share waits for one and is
+// handed over by claimShares once it is confirmed, so sharing answers the
+// same whether the email is registered or not. Sharing again with the same
+// email replaces the role.
+func (sr *noteShareRepository) Share(ctx context.Context, ownerId, noteId int64, email, role string) (*models.NoteShare, error) {
+	var exists bool
+	query := `SELECT EXISTS (SELECT 1 FROM notes WHERE id = $1 AND user_id = $2 AND deleted_at IS NULL)`
+	if err := sr.db.QueryRow(ctx, query, noteId, ownerId).Scan(&exists); err != nil {
+		return nil, newRepositoryError(err)
+	}
+	if !exists {
+		return nil, ErrNoteNotFound
+	}
+
+	var share models.NoteShare
+	query = `SELECT id, email FROM users WHERE lower(email) = $1`
+	err := sr.db.QueryRow(ctx, query, normalizeEmail(email)).Scan(&share.UserId, &share.Email)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return sr.invite(ctx, noteId, normalizeEmail(email), role)
+	}
+	if err != nil {
+		return nil, newRepositoryError(err)
+	}
+	if share.UserId.Int.Int64() == ownerId {
+		return nil, ErrShareWithOwner
+	}
+
+	query = `
+	INSERT INTO note_shares (note_id, user_id, role)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (note_id, user_id) DO UPDATE SET role = EXCLUDED.role
+		RETURNING id, note_id, role, created_at`
+	row := sr.db.QueryRow(ctx, query, noteId, share.UserId, role)
+	if err := row.Scan(&share.Id, &share.NoteId, &share.Role, &share.CreatedAt); err != nil {
+		return nil, fail(err)
+	}
+
+	return &share, nil
+}
+
+// invite keeps a share with an email that has no account yet.
+func (sr *noteShareRepository) invite(ctx context.Context, noteId int64, email, role string) (*models.NoteShare, error) {
+	var share models.NoteShare
+	query := `
+	INSERT INTO note_shares (note_id, email, role)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (note_id, email) DO UPDATE SET role = EXCLUDED.role
+		RETURNING id, note_id, email, role, created_at`
+	row := sr.db.QueryRow(ctx, query, noteId, email, role)
+	if err := row.Scan(&share.Id, &share.NoteId, &share.Email, &share.Role, &share.CreatedAt); err != nil {
+		return nil, fail(err)
+	}
+
+	return &share, nil
+}
+
+// claimShares hands the shares waiting for the email of the user over to
+// it, in the transaction that confirmed the account or its new email.
+// Notes of the user and notes already shared with it are skipped.
+func claimShares(ctx context.Context, tx pgx.Tx, userId pgtype.Numeric) error {
+	query := `
+	UPDATE note_shares s SET user_id = u.id, email = NULL
+		FROM users u, notes n
+		WHERE u.id = $1 AND s.user_id IS NULL AND s.email = u.email
+		AND n.id = s.note_id AND n.user_id <> u.id
+		AND NOT EXISTS (SELECT 1 FROM note_shares o WHERE o.note_id = s.note_id AND o.user_id = u.id)`
+	if _, err := tx.Exec(ctx, query, userId); err != nil {
+		return fail(err)
+	}
+
+	query = `
+	DELETE FROM note_shares s
+		USING users u
+		WHERE u.id = $1 AND s.user_id IS NULL AND s.email = u.email`
+	if _, err := tx.Exec(ctx, query, userId); err != nil {
+		return fail(err)
+	}
+
+	return nil
+}
+
+// List returns the users and the emails still without an account the
+// owner's note is shared with, which are not told apart.
+func (sr *noteShareRepository) List(ctx context.Context, ownerId, noteId int64) ([]models.NoteShare, error) {
+	var shares []models.NoteShare
+	query := `
+	SELECT s.id, s.note_id, s.user_id, coalesce(u.email, s.email) AS email, s.role, s.created_at
+		FROM note_shares s
+		INNER JOIN notes n ON n.id = s.note_id
+		LEFT JOIN users u ON u.id = s.user_id
+		WHERE s.note_id = $1 AND n.user_id = $2
+		ORDER BY email`
+
+	rows, err := sr.db.Query(ctx, query, noteId, ownerId)
+	if err != nil {
+		return nil, newRepositoryError(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		share := models.NoteShare{}
+		err := rows.Scan(
+			&share.Id,
+			&share.NoteId,
+			&share.UserId,
+			&share.Email,
+			&share.Role,
+			&share.CreatedAt)
+		if err != nil {
+			return nil, newRepositoryError(err)
+		}
+		shares = append(shares, share)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, newRepositoryError(err)
+	}
+
+	return shares, nil
+}
+
+// Unshare revokes a share of the owner's note.
+func (sr *noteShareRepository) Unshare(ctx context.Context, ownerId, noteId, id int64) error {
+	query := `
+	DELETE FROM note_shares s
+		USING notes n
+		WHERE s.id = $1 AND s.note_id = $2 AND n.id = s.note_id AND n.user_id = $3`
+
+	tag, err := sr.db.Exec(ctx, query, id, noteId, ownerId)
+	if err != nil {
+		return newRepositoryError(err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrShareNotFound
+	}
+
+	return nil
+}
+
+// GetSharedNote returns a note another user shared with userId, failing
+// with ErrNoteNotFound when there is no such share.
+func (sr *noteShareRepository) GetSharedNote(ctx context.Context, userId, noteId int64) (*models.SharedNote, error) {
+	var note models.SharedNote
+	query := `
+	SELECT notes.id, notes.user_id, notes.title, notes.content, notes.color, notes.kind, notes.version,
+			notes.pinned, notes.archived, notes.created_at, notes.updated_at, ` + noteTagsColumn + `,
+			s.role, u.email
+		FROM notes
+		INNER JOIN note_shares s ON s.note_id = notes.id
+		INNER JOIN users u ON u.id = notes.user_id
+		WHERE notes.id = $1 AND s.user_id = $2 AND notes.deleted_at IS NULL`
+
+	row := sr.db.QueryRow(ctx, query, noteId, userId)
+	if err := row.Scan(
+		&note.Id,
+		&note.UserId,
+		&note.Title,
+		&note.Content,
+		&note.Color,
+		&note.Kind,
+		&note.Version,
+		&note.Pinned,
+		&note.Archived,
+		&note.CreatedAt,
+		&note.UpdatedAt,
+		&note.Tags,
+		&note.Role,
+		&note.OwnerEmail,
+	); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNoteNotFound
+		}
+		return nil, newRepositoryError(err)
+	}
+
+	return &note, nil
+}
+
+// ListSharedWithMe returns the notes other users shared with userId,
+// most recently changed first.
+func (sr *noteShareRepository) ListSharedWithMe(ctx context.Context, userId int64) ([]models.SharedNote, error) {
+	var notes []models.SharedNote
+	query := `
+	SELECT notes.id, notes.user_id, notes.title, notes.content, notes.color, notes.kind, notes.version,
+			notes.pinned, notes.archived, notes.created_at, notes.updated_at, ` + noteTagsColumn + `,
+			` + checklistCountColumns + `,
+			s.role, u.email
+		FROM notes
+		INNER JOIN note_shares s ON s.note_id = notes.id
+		INNER JOIN users u ON u.id = notes.user_id
+		WHERE s.user_id = $1 AND notes.deleted_at IS NULL
+		ORDER BY coalesce(notes.updated_at, notes.created_at) DESC, notes.id DESC`
+
+	rows, err := sr.db.Query(ctx, query, userId)
+	if err != nil {
+		return nil, newRepositoryError(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		note := models.SharedNote{}
+		err := rows.Scan(
+			&note.Id,
+			&note.UserId,
+			&note.Title,
+			&note.Content,
+			&note.Color,
+			&note.Kind,
+			&note.Version,
+			&note.Pinned,
+			&note.Archived,
+			&note.CreatedAt,
+			&note.UpdatedAt,
+			&note.Tags,
+			&note.ItemsDone,
+			&note.ItemsTotal,
+			&note.Role,
+			&note.OwnerEmail)
+		if err != nil {
+			return nil, newRepositoryError(err)
+		}
+		notes = append(notes, note)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, newRepositoryError(err)
+	}
+
+	return notes, nil
+}
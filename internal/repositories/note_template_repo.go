@@ -0,0 +1,142 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/rudsonalves/quicknotes/internal/models"
+)
+
+var ErrNoteTemplateNotFound = newRepositoryError(errors.New("note template not found"))
+
+type NoteTemplateRepository interface {
+	Create(ctx context.Context, userId int64, title, content, color string) (*models.NoteTemplate, error)
+	GetById(ctx context.Context, userId, id int64) (*models.NoteTemplate, error)
+	List(ctx context.Context, userId int64) ([]models.NoteTemplate, error)
+	Update(ctx context.Context, userId, id int64, title, content, color string) error
+	Delete(ctx context.Context, userId, id int64) error
+}
+
+type noteTemplateRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewNoteTemplateRepository(dbpool *pgxpool.Pool) NoteTemplateRepository {
+	return &noteTemplateRepository{db: dbpool}
+}
+
+func (tr *noteTemplateRepository) Create(ctx context.Context, userId int64, title, content, color string) (*models.NoteTemplate, error) {
+	var tmpl models.NoteTemplate
+	tmpl.Title = pgtype.Text{String: title, Valid: true}
+	tmpl.Content = pgtype.Text{String: content, Valid: true}
+	tmpl.Color = pgtype.Text{String: color, Valid: true}
+
+	query := `
+	INSERT INTO note_templates (user_id, title, content, color)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, user_id, created_at`
+
+	row := tr.db.QueryRow(ctx, query, userId, tmpl.Title, tmpl.Content, tmpl.Color)
+	if err := row.Scan(&tmpl.Id, &tmpl.UserId, &tmpl.CreatedAt); err != nil {
+		return nil, fail(err)
+	}
+
+	return &tmpl, nil
+}
+
+func (tr *noteTemplateRepository) GetById(ctx context.Context, userId, id int64) (*models.NoteTemplate, error) {
+	var tmpl models.NoteTemplate
+	query := `
+	SELECT id, user_id, title, content, color, created_at, updated_at
+		FROM note_templates
+		WHERE id = $1 AND user_id = $2`
+
+	row := tr.db.QueryRow(ctx, query, id, userId)
+	if err := row.Scan(
+		&tmpl.Id,
+		&tmpl.UserId,
+		&tmpl.Title,
+		&tmpl.Content,
+		&tmpl.Color,
+		&tmpl.CreatedAt,
+		&tmpl.UpdatedAt,
+	); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNoteTemplateNotFound
+		}
+		return nil, newRepositoryError(err)
+	}
+
+	return &tmpl, nil
+}
+
+// List returns the user's templates sorted by title.
+func (tr *noteTemplateRepository) List(ctx context.Context, userId int64) ([]models.NoteTemplate, error) {
+	var templates []models.NoteTemplate
+	query := `
+	SELECT id, user_id, title, content, color, created_at, updated_at
+		FROM note_templates
+		WHERE user_id = $1
+		ORDER BY lower(title), id`
+
+	rows, err := tr.db.Query(ctx, query, userId)
+	if err != nil {
+		return nil, newRepositoryError(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		tmpl := models.NoteTemplate{}
+		err := rows.Scan(
+			&tmpl.Id,
+			&tmpl.UserId,
+			&tmpl.Title,
+			&tmpl.Content,
+			&tmpl.Color,
+			&tmpl.CreatedAt,
+			&tmpl.UpdatedAt)
+		if err != nil {
+			return nil, newRepositoryError(err)
+		}
+		templates = append(templates, tmpl)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, newRepositoryError(err)
+	}
+
+	return templates, nil
+}
+
+func (tr *noteTemplateRepository) Update(ctx context.Context, userId, id int64, title, content, color string) error {
+	query := `
+	UPDATE note_templates SET title = $3, content = $4, color = $5, updated_at = now()
+		WHERE id = $1 AND user_id = $2`
+
+	tag, err := tr.db.Exec(ctx, query, id, userId, title, content, color)
+	if err != nil {
+		return newRepositoryError(err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNoteTemplateNotFound
+	}
+
+	return nil
+}
+
+func (tr *noteTemplateRepository) Delete(ctx context.Context, userId, id int64) error {
+	query := `DELETE FROM note_templates WHERE id = $1 AND user_id = $2`
+
+	tag, err := tr.db.Exec(ctx, query, id, userId)
+	if err != nil {
+		return newRepositoryError(err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNoteTemplateNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,234 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/rudsonalves/quicknotes/internal/models"
+)
+
+var ErrNotebookNotFound = newRepositoryError(errors.New("notebook not found"))
+var ErrNotebookCycle = newRepositoryError(errors.New("notebook cannot be moved into itself"))
+
+type NotebookRepository interface {
+	Create(ctx context.Context, userId int64, name string, parentId *int64) (*models.Notebook, error)
+	GetById(ctx context.Context, userId, id int64) (*models.Notebook, error)
+	List(ctx context.Context, userId int64) ([]models.Notebook, error)
+	Update(ctx context.Context, userId, id int64, name string, parentId *int64) (*models.Notebook, error)
+	Delete(ctx context.Context, userId, id int64) error
+}
+
+type notebookRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewNotebookRepository(dbpool *pgxpool.Pool) NotebookRepository {
+	return &notebookRepository{db: dbpool}
+}
+
+// Create adds a notebook, nested in parentId when it is not nil. The
+// parent must belong to the same user.
+func (br *notebookRepository) Create(ctx context.Context, userId int64, name string, parentId *int64) (*models.Notebook, error) {
+	var notebook models.Notebook
+	notebook.Name = pgtype.Text{String: name, Valid: true}
+
+	query := `
+	INSERT INTO notebooks (user_id, parent_id, name)
+		SELECT $1, $2, $3
+		WHERE $2::bigint IS NULL OR EXISTS (SELECT 1 FROM notebooks WHERE id = $2 AND user_id = $1)
+		RETURNING id, user_id, parent_id, created_at`
+
+	row := br.db.QueryRow(ctx, query, userId, parentId, name)
+	if err := row.Scan(&notebook.Id, &notebook.UserId, &notebook.ParentId, &notebook.CreatedAt); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotebookNotFound
+		}
+		return nil, fail(err)
+	}
+
+	return &notebook, nil
+}
+
+func (br *notebookRepository) GetById(ctx context.Context, userId, id int64) (*models.Notebook, error) {
+	var notebook models.Notebook
+	query := `
+	SELECT id, user_id, parent_id, name, created_at
+		FROM notebooks
+		WHERE id = $1 AND user_id = $2`
+
+	row := br.db.QueryRow(ctx, query, id, userId)
+	if err := row.Scan(
+		&notebook.Id,
+		&notebook.UserId,
+		&notebook.ParentId,
+		&notebook.Name,
+		&notebook.CreatedAt,
+	); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotebookNotFound
+		}
+		return nil, newRepositoryError(err)
+	}
+
+	return &notebook, nil
+}
+
+// List returns the user's notebooks in tree order, each child right after
+// its parent and siblings sorted by name, along with their depth and the
+// number of notes directly inside them.
+func (br *notebookRepository) List(ctx context.Context, userId int64) ([]models.Notebook, error) {
+	var notebooks []models.Notebook
+	query := `
+	WITH RECURSIVE tree AS (
+		SELECT id, parent_id, name, created_at, 0 AS depth,
+				ARRAY[lower(name) || ':' || id] AS path
+			FROM notebooks
+			WHERE user_id = $1 AND parent_id IS NULL
+		UNION ALL
+		SELECT nb.id, nb.parent_id, nb.name, nb.created_at, tree.depth + 1,
+				tree.path || (lower(nb.name) || ':' || nb.id)
+			FROM notebooks nb INNER JOIN tree ON nb.parent_id = tree.id
+	)
+	SELECT tree.id, tree.parent_id, tree.name, tree.created_at, tree.depth,
+			(SELECT count(*) FROM notes
+				WHERE notes.notebook_id = tree.id AND notes.deleted_at IS NULL)
+		FROM tree
+		ORDER BY tree.path`
+
+	rows, err := br.db.Query(ctx, query, userId)
+	if err != nil {
+		return nil, newRepositoryError(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		notebook := models.Notebook{}
+		err := rows.Scan(
+			&notebook.Id,
+			&notebook.ParentId,
+			&notebook.Name,
+			&notebook.CreatedAt,
+			&notebook.Depth,
+			&notebook.NoteCount)
+		if err != nil {
+			return nil, newRepositoryError(err)
+		}
+		notebooks = append(notebooks, notebook)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, newRepositoryError(err)
+	}
+
+	return notebooks, nil
+}
+
+// Update renames the notebook and moves it under parentId, failing with
+// ErrNotebookCycle when the parent is the notebook itself or one of its
+// descendants.
+func (br *notebookRepository) Update(ctx context.Context, userId, id int64, name string, parentId *int64) (*models.Notebook, error) {
+	tx, err := br.db.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return nil, fail(err)
+	}
+	defer tx.Rollback(ctx)
+
+	if parentId != nil {
+		// concurrent moves of two notebooks under each other would both
+		// pass the check below without the lock
+		if err := lockNotebooks(ctx, tx, userId); err != nil {
+			return nil, err
+		}
+
+		var isDescendant bool
+		query := `
+		WITH RECURSIVE descendants AS (
+			SELECT id FROM notebooks WHERE id = $1 AND user_id = $2
+			UNION
+			SELECT nb.id FROM notebooks nb INNER JOIN descendants d ON nb.parent_id = d.id
+		)
+		SELECT EXISTS (SELECT 1 FROM descendants WHERE id = $3)`
+		if err := tx.QueryRow(ctx, query, id, userId, *parentId).Scan(&isDescendant); err != nil {
+			return nil, fail(err)
+		}
+		if isDescendant {
+			return nil, ErrNotebookCycle
+		}
+	}
+
+	var notebook models.Notebook
+	query := `
+	UPDATE notebooks SET name = $3, parent_id = $4
+		WHERE id = $1 AND user_id = $2
+		AND ($4::bigint IS NULL OR EXISTS (SELECT 1 FROM notebooks WHERE id = $4 AND user_id = $2))
+		RETURNING id, user_id, parent_id, name, created_at`
+	row := tx.QueryRow(ctx, query, id, userId, name, parentId)
+	if err := row.Scan(
+		&notebook.Id,
+		&notebook.UserId,
+		&notebook.ParentId,
+		&notebook.Name,
+		&notebook.CreatedAt,
+	); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotebookNotFound
+		}
+		return nil, fail(err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fail(err)
+	}
+
+	return &notebook, nil
+}
+
+// lockNotebooks locks the notebooks of the user until the end of tx, so
+// the tree cannot change between checking a move and making it.
+func lockNotebooks(ctx context.Context, tx pgx.Tx, userId int64) error {
+	query := `SELECT id FROM notebooks WHERE user_id = $1 ORDER BY id FOR UPDATE`
+	if _, err := tx.Exec(ctx, query, userId); err != nil {
+		return fail(err)
+	}
+	return nil
+}
+
+// Delete removes the notebook. Its sub-notebooks move up to its parent and
+// its notes are left without a notebook.
+func (br *notebookRepository) Delete(ctx context.Context, userId, id int64) error {
+	tx, err := br.db.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return fail(err)
+	}
+	defer tx.Rollback(ctx)
+
+	if err := lockNotebooks(ctx, tx, userId); err != nil {
+		return err
+	}
+
+	query := `
+	UPDATE notebooks child SET parent_id = nb.parent_id
+		FROM notebooks nb
+		WHERE child.parent_id = nb.id AND nb.id = $1 AND nb.user_id = $2`
+	if _, err := tx.Exec(ctx, query, id, userId); err != nil {
+		return fail(err)
+	}
+
+	query = `DELETE FROM notebooks WHERE id = $1 AND user_id = $2`
+	tag, err := tx.Exec(ctx, query, id, userId)
+	if err != nil {
+		return fail(err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotebookNotFound
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fail(err)
+	}
+
+	return nil
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"strings"
+	"time"
 
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgconn"
@@ -16,14 +17,28 @@ var ErrDuplicateEmail = newRepositoryError(errors.New("duplicate email"))
 var ErrEmailNotFound = newRepositoryError(errors.New("email not found"))
 var ErrInvalidTokenOrUserAlreadyConfirmed = newRepositoryError(errors.New("invalid token or user already confirmed"))
 
+// UserRepository manages the users and the tokens mailed to them. Tokens
+// are only stored and looked up by their hash, see utils.HashToken.
 type UserRepository interface {
-	Create(ctx context.Context, email, password, hashToken string) (*models.User, string, error)
+	Create(ctx context.Context, email, password, tokenHash string) (*models.User, error)
 	FindByEmail(ctx context.Context, email string) (*models.User, error)
-	ConfirmUserByToken(ctx context.Context, token string) error
-	CreateResetPasswordToken(ctx context.Context, email, hashToken string) (string, error)
-	GetUserConfirmationByToken(ctx context.Context, token string) (*models.UserConfirmationToken, error)
-	UpdatePasswordByToken(ctx context.Context, newPassword, token string) (string, error)
-	// NewUserConfirmationToken(ctx context.Context, user *models.User, token string) (*models.UserConfirmationToken, error)
+	FindById(ctx context.Context, id int64) (*models.User, error)
+	ConfirmUserByToken(ctx context.Context, tokenHash string) error
+	CreateResetPasswordToken(ctx context.Context, email, tokenHash string) error
+	GetUserConfirmationByToken(ctx context.Context, tokenHash, purpose string) (*models.UserConfirmationToken, error)
+	UpdatePasswordByToken(ctx context.Context, newPassword, tokenHash string) (string, error)
+	DeleteExpiredTokens(ctx context.Context, before time.Time) (int64, error)
+	Update(ctx context.Context, user *models.User) error
+	UpdatePassword(ctx context.Context, userId int64, password string) error
+	RequestEmailChange(ctx context.Context, userId int64, email, tokenHash string) error
+	ConfirmEmailChange(ctx context.Context, tokenHash string) (*models.User, error)
+	EnableTOTP(ctx context.Context, userId int64, secret string, step int64, codeHashes []string) error
+	DisableTOTP(ctx context.Context, userId int64) error
+	UseTOTPStep(ctx context.Context, userId, step int64) (bool, error)
+	UseRecoveryCode(ctx context.Context, userId int64, code string) (bool, error)
+	ReplaceRecoveryCodes(ctx context.Context, userId int64, codeHashes []string) error
+	CountRecoveryCodes(ctx context.Context, userId int64) (int, error)
+	NewUserConfirmationToken(ctx context.Context, user *models.User, tokenHash string) (*models.UserConfirmationToken, error)
 }
 
 type userRepository struct {
@@ -34,46 +49,46 @@ func NewUserRepository(dbpoll *pgxpool.Pool) UserRepository {
 	return &userRepository{db: dbpoll}
 }
 
-func (ur *userRepository) getUserIdEmailTokenIdFromToken(ctx context.Context, token string) (userId pgtype.Numeric, email pgtype.Text, tokenId pgtype.Numeric, err error) {
+// claimUserToken marks a token as used inside tx, returning the id and
+// email of its user. Tokens already used, expired or made for another
+// purpose are refused, and the row lock makes concurrent claims of the same
+// token fail.
+func claimUserToken(ctx context.Context, tx pgx.Tx, tokenHash, purpose string) (userId pgtype.Numeric, email pgtype.Text, err error) {
 	query := `
-	SELECT u.id u_id, u.email, t.id t_id FROM users u INNER JOIN users_conf_tokens t
-		ON u.id = t.user_id
-		WHERE t.confirmed = false
-		AND t.token = $1`
-
-	row := ur.db.QueryRow(ctx, query, token)
-	err = row.Scan(&userId, &email, &token)
-	return
-}
-
-func (ur *userRepository) UpdatePasswordByToken(ctx context.Context, newPassword string, token string) (string, error) {
-	userId, email, tokenId, err := ur.getUserIdEmailTokenIdFromToken(ctx, token)
-	if err != nil {
-		if err == pgx.ErrNoRows {
-			return "", ErrInvalidTokenOrUserAlreadyConfirmed
+	UPDATE users_conf_tokens t SET confirmed = true, updated_at = now()
+		FROM users u
+		WHERE u.id = t.user_id
+		AND t.token_hash = $1 AND t.purpose = $2
+		AND t.confirmed = false AND t.expires_at > now()
+		RETURNING u.id, u.email`
+
+	row := tx.QueryRow(ctx, query, tokenHash, purpose)
+	if err = row.Scan(&userId, &email); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			err = ErrInvalidTokenOrUserAlreadyConfirmed
+			return
 		}
-		return "", newRepositoryError(err)
+		err = fail(err)
 	}
+	return
+}
 
+func (ur *userRepository) UpdatePasswordByToken(ctx context.Context, newPassword string, tokenHash string) (string, error) {
 	// transaction scope
 	tx, err := ur.db.BeginTx(ctx, pgx.TxOptions{})
 	if err != nil {
-		fail(err)
+		return "", fail(err)
 	}
 	defer tx.Rollback(ctx)
 
-	// update confirmation token
-	query := `
-	UPDATE users_conf_tokens
-		SET confirmed = true, updated_at = now()
-		WHERE id = $1`
-	_, err = tx.Exec(ctx, query, tokenId)
+	// use the reset token
+	userId, email, err := claimUserToken(ctx, tx, tokenHash, models.TokenPurposePasswordReset)
 	if err != nil {
-		return "", fail(err)
+		return "", err
 	}
 
 	// update user password
-	query = `
+	query := `
 	UPDATE users
 		SET password = $1, updated_at = now()
 		WHERE id = $2`
@@ -89,51 +104,30 @@ func (ur *userRepository) UpdatePasswordByToken(ctx context.Context, newPassword
 	return email.String, nil
 }
 
-func (ur *userRepository) CreateResetPasswordToken(ctx context.Context, email, hashToken string) (string, error) {
+func (ur *userRepository) CreateResetPasswordToken(ctx context.Context, email, tokenHash string) error {
 	user, err := ur.FindByEmail(ctx, email)
 	if err != nil || !user.Active.Bool {
-		return "", fail(ErrEmailNotFound)
+		return fail(ErrEmailNotFound)
 	}
 
 	tx, err := ur.db.BeginTx(ctx, pgx.TxOptions{})
 	if err != nil {
-		return "", fail(err)
+		return fail(err)
 	}
 	defer tx.Rollback(ctx)
 
-	userToken, err := ur.createConfirmationToken(tx, ctx, user, hashToken)
-	if err != nil {
-		return "", fail(ErrEmailNotFound)
+	if _, err := createUserToken(ctx, tx, user.Id, tokenHash, models.TokenPurposePasswordReset); err != nil {
+		return err
 	}
 
 	if err := tx.Commit(ctx); err != nil {
-		return "", fail(err)
+		return fail(err)
 	}
 
-	return userToken.Token.String, nil
-}
-
-func (ur *userRepository) fetchUserDetailsByToken(ctx context.Context, token string) (userId pgtype.Numeric, totokenId pgtype.Numeric, err error) {
-	query := `
-	SELECT u.id, t.id FROM users u INNER JOIN users_conf_tokens t
-		ON u.id = t.user_id
-		WHERE u.active = false
-		AND t.confirmed = false
-		AND t.token = $1`
-	row := ur.db.QueryRow(ctx, query, token)
-	err = row.Scan(&userId, &totokenId)
-	return
+	return nil
 }
 
-func (ur *userRepository) ConfirmUserByToken(ctx context.Context, token string) error {
-	userId, totokenId, err := ur.fetchUserDetailsByToken(ctx, token)
-	if err != nil {
-		if err == pgx.ErrNoRows {
-			return ErrInvalidTokenOrUserAlreadyConfirmed
-		}
-		return fail(err)
-	}
-
+func (ur *userRepository) ConfirmUserByToken(ctx context.Context, tokenHash string) error {
 	// Transaction scope
 	tx, err := ur.db.BeginTx(ctx, pgx.TxOptions{})
 	if err != nil {
@@ -141,17 +135,23 @@ func (ur *userRepository) ConfirmUserByToken(ctx context.Context, token string)
 	}
 	defer tx.Rollback(ctx)
 
-	queryUpdateUser := `UPDATE users SET active = true, updated_at = now() WHERE id = $1`
-	_, err = tx.Exec(ctx, queryUpdateUser, userId)
+	userId, _, err := claimUserToken(ctx, tx, tokenHash, models.TokenPurposeConfirmation)
 	if err != nil {
-		return fail(err)
+		return err
 	}
 
-	queryUpdateToken := `UPDATE users_conf_tokens SET confirmed = true, updated_at = now() WHERE id = $1`
-	_, err = tx.Exec(ctx, queryUpdateToken, totokenId)
+	queryUpdateUser := `UPDATE users SET active = true, updated_at = now() WHERE id = $1 AND active = false`
+	tag, err := tx.Exec(ctx, queryUpdateUser, userId)
 	if err != nil {
 		return fail(err)
 	}
+	if tag.RowsAffected() == 0 {
+		return ErrInvalidTokenOrUserAlreadyConfirmed
+	}
+
+	if err := claimShares(ctx, tx, userId); err != nil {
+		return err
+	}
 
 	if err := tx.Commit(ctx); err != nil {
 		return fail(err)
@@ -160,31 +160,59 @@ func (ur *userRepository) ConfirmUserByToken(ctx context.Context, token string)
 	return nil
 }
 
-func (ur *userRepository) createConfirmationToken(tx pgx.Tx, ctx context.Context, user *models.User, token string) (*models.UserConfirmationToken, error) {
-	var userTotken models.UserConfirmationToken
-	userTotken.UserId = user.Id
-	userTotken.Token = pgtype.Text{String: token, Valid: true}
+// createUserToken stores the hash of a new token, replacing the pending
+// tokens of the user with the same purpose, so only the last link mailed
+// works.
+func createUserToken(ctx context.Context, tx pgx.Tx, userId pgtype.Numeric, tokenHash, purpose string) (*models.UserConfirmationToken, error) {
 	query := `
-	INSERT INTO users_conf_tokens (user_id, token)
-		VALUES($1, $2)
+	DELETE FROM users_conf_tokens
+		WHERE user_id = $1 AND purpose = $2 AND confirmed = false`
+	if _, err := tx.Exec(ctx, query, userId, purpose); err != nil {
+		return nil, fail(err)
+	}
+
+	var userToken models.UserConfirmationToken
+	userToken.UserId = userId
+	userToken.TokenHash = pgtype.Text{String: tokenHash, Valid: true}
+	userToken.Purpose = pgtype.Text{String: purpose, Valid: true}
+	userToken.ExpiresAt = pgtype.Timestamptz{Time: time.Now().Add(models.TokenLifetimes[purpose]), Valid: true}
+	query = `
+	INSERT INTO users_conf_tokens (user_id, token_hash, purpose, expires_at)
+		VALUES($1, $2, $3, $4)
 		RETURNING id, created_at`
 
-	row := tx.QueryRow(ctx, query, userTotken.UserId, userTotken.Token)
-	if err := row.Scan(&userTotken.Id, &userTotken.CreatedAt); err != nil {
+	row := tx.QueryRow(ctx, query, userToken.UserId, userToken.TokenHash, userToken.Purpose, userToken.ExpiresAt)
+	if err := row.Scan(&userToken.Id, &userToken.CreatedAt); err != nil {
 		return nil, fail(err)
 	}
 
-	return &userTotken, nil
+	return &userToken, nil
+}
+
+// normalizeEmail is how emails are stored and looked up, so the same
+// address typed in another case is the same account.
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// isDuplicateEmail reports whether err violates the unique email
+// constraint or the unique index on lower(email).
+func isDuplicateEmail(err error) bool {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) || pgErr.Code != "23505" {
+		return false
+	}
+	return pgErr.ConstraintName == "users_email_key" || pgErr.ConstraintName == "users_lower_email_idx"
 }
 
-func (ur *userRepository) Create(ctx context.Context, email string, password string, hashToken string) (*models.User, string, error) {
+func (ur *userRepository) Create(ctx context.Context, email string, password string, tokenHash string) (*models.User, error) {
 	var user models.User
-	user.Email = pgtype.Text{String: strings.TrimSpace(email), Valid: true}
+	user.Email = pgtype.Text{String: normalizeEmail(email), Valid: true}
 	user.Password = pgtype.Text{String: strings.TrimSpace(password), Valid: true}
 
 	tx, err := ur.db.BeginTx(ctx, pgx.TxOptions{})
 	if err != nil {
-		return nil, "", fail(err)
+		return nil, fail(err)
 	}
 	defer tx.Rollback(ctx)
 
@@ -195,69 +223,218 @@ func (ur *userRepository) Create(ctx context.Context, email string, password str
 
 	row := tx.QueryRow(ctx, query, user.Email, user.Password)
 	if err := row.Scan(&user.Id, &user.CreatedAt); err != nil {
-		if pgErr, ok := err.(*pgconn.PgError); ok && pgErr.Code == "23505" {
-			return &user, "", fail(ErrDuplicateEmail)
+		if isDuplicateEmail(err) {
+			return &user, fail(ErrDuplicateEmail)
 		}
-		return nil, "", fail(err)
+		return nil, fail(err)
 	}
 
 	// generate token confirmation
-	userToken, err := ur.createConfirmationToken(tx, ctx, &user, hashToken)
-	if err != nil {
-		return nil, "", fail(err)
+	if _, err := createUserToken(ctx, tx, user.Id, tokenHash, models.TokenPurposeConfirmation); err != nil {
+		return nil, err
 	}
 
 	if err := tx.Commit(ctx); err != nil {
-		return nil, "", fail(err)
+		return nil, fail(err)
 	}
 
-	return &user, userToken.Token.String, nil
+	return &user, nil
 }
 
-func (ur *userRepository) FindByEmail(ctx context.Context, email string) (*models.User, error) {
-	var user models.User
-	query := `SELECT id, email, password, active FROM users WHERE email = $1`
+// userColumns are the columns read by scanUser.
+const userColumns = `id, email, password, active, totp_secret, display_name, pending_email, timezone`
 
-	row := ur.db.QueryRow(ctx, query, email)
-	if err := row.Scan(
+func scanUser(row pgx.Row) (*models.User, error) {
+	var user models.User
+	err := row.Scan(
 		&user.Id,
 		&user.Email,
 		&user.Password,
 		&user.Active,
-	); err != nil {
+		&user.TOTPSecret,
+		&user.DisplayName,
+		&user.PendingEmail,
+		&user.Timezone)
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (ur *userRepository) FindByEmail(ctx context.Context, email string) (*models.User, error) {
+	// lower() matches the unique index on lower(email)
+	query := `SELECT ` + userColumns + ` FROM users WHERE lower(email) = $1`
+
+	user, err := scanUser(ur.db.QueryRow(ctx, query, normalizeEmail(email)))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrEmailNotFound
+		}
 		return nil, newRepositoryError(err)
 	}
 
-	return &user, nil
+	return user, nil
+}
+
+func (ur *userRepository) FindById(ctx context.Context, id int64) (*models.User, error) {
+	query := `SELECT ` + userColumns + ` FROM users WHERE id = $1`
+
+	user, err := scanUser(ur.db.QueryRow(ctx, query, id))
+	if err != nil {
+		return nil, newRepositoryError(err)
+	}
+
+	return user, nil
 }
 
-func (ur *userRepository) GetUserConfirmationByToken(ctx context.Context, token string) (*models.UserConfirmationToken, error) {
+// Update saves the profile and the preferences of the user. The email and
+// the password have their own methods.
+func (ur *userRepository) Update(ctx context.Context, user *models.User) error {
+	query := `
+	UPDATE users
+		SET display_name = $2, timezone = $3, updated_at = now()
+		WHERE id = $1`
+
+	_, err := ur.db.Exec(ctx, query, user.Id, user.DisplayName, user.Timezone)
+	if err != nil {
+		return fail(err)
+	}
+	return nil
+}
+
+func (ur *userRepository) UpdatePassword(ctx context.Context, userId int64, password string) error {
+	query := `UPDATE users SET password = $2, updated_at = now() WHERE id = $1`
+
+	if _, err := ur.db.Exec(ctx, query, userId, password); err != nil {
+		return fail(err)
+	}
+	return nil
+}
+
+// RequestEmailChange keeps the new email as pending until it is confirmed
+// with the token mailed to it.
+func (ur *userRepository) RequestEmailChange(ctx context.Context, userId int64, email, tokenHash string) error {
+	tx, err := ur.db.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return fail(err)
+	}
+	defer tx.Rollback(ctx)
+
+	var id pgtype.Numeric
+	query := `
+	UPDATE users SET pending_email = $2, updated_at = now()
+		WHERE id = $1
+		RETURNING id`
+	if err := tx.QueryRow(ctx, query, userId, normalizeEmail(email)).Scan(&id); err != nil {
+		return fail(err)
+	}
+
+	if _, err := createUserToken(ctx, tx, id, tokenHash, models.TokenPurposeEmailChange); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fail(err)
+	}
+
+	return nil
+}
+
+// ConfirmEmailChange replaces the email of the user by the pending one,
+// returning the user with the new email. It fails with ErrDuplicateEmail
+// when another account took the email in the meantime.
+func (ur *userRepository) ConfirmEmailChange(ctx context.Context, tokenHash string) (*models.User, error) {
+	tx, err := ur.db.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return nil, fail(err)
+	}
+	defer tx.Rollback(ctx)
+
+	userId, _, err := claimUserToken(ctx, tx, tokenHash, models.TokenPurposeEmailChange)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+	UPDATE users SET email = pending_email, pending_email = NULL, updated_at = now()
+		WHERE id = $1 AND pending_email IS NOT NULL
+		RETURNING ` + userColumns
+	user, err := scanUser(tx.QueryRow(ctx, query, userId))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrInvalidTokenOrUserAlreadyConfirmed
+		}
+		if isDuplicateEmail(err) {
+			return nil, ErrDuplicateEmail
+		}
+		return nil, fail(err)
+	}
+
+	if err := claimShares(ctx, tx, user.Id); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fail(err)
+	}
+
+	return user, nil
+}
+
+// GetUserConfirmationByToken returns the token if it can still be used
+// for purpose.
+func (ur *userRepository) GetUserConfirmationByToken(ctx context.Context, tokenHash, purpose string) (*models.UserConfirmationToken, error) {
 	var userToken models.UserConfirmationToken
 	query := `
-	SELECT id, user_id, token, confirmed, created_at, updated_at
+	SELECT id, user_id, token_hash, purpose, confirmed, expires_at
 		FROM users_conf_tokens
-		WHERE token = $1`
+		WHERE token_hash = $1 AND purpose = $2
+		AND confirmed = false AND expires_at > now()`
 
-	row := ur.db.QueryRow(ctx, query, token)
+	row := ur.db.QueryRow(ctx, query, tokenHash, purpose)
 	if err := row.Scan(
 		&userToken.Id,
 		&userToken.UserId,
-		&userToken.Token,
+		&userToken.TokenHash,
+		&userToken.Purpose,
 		&userToken.Confirmed,
-		&userToken.CreatedAt,
-		&userToken.UpdatedAt); err != nil {
+		&userToken.ExpiresAt); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrInvalidTokenOrUserAlreadyConfirmed
+		}
 		return nil, newRepositoryError(err)
 	}
 
 	return &userToken, nil
 }
 
-// func (u *userRepository) NewUserConfirmationToken(ctx context.Context, user *models.User, token string) (*models.UserConfirmationToken, error) {
-// 	userToken, err := u.createConfirmationToken(ctx, user, token)
-// 	if err != nil {
-// 		slog.Error(err.Error())
-// 		return nil, newRepositoryError(err)
-// 	}
+// NewUserConfirmationToken replaces the pending confirmation tokens of a
+// user not confirmed yet by a new one, so only the last mail sent works.
+func (ur *userRepository) NewUserConfirmationToken(ctx context.Context, user *models.User, tokenHash string) (*models.UserConfirmationToken, error) {
+	tx, err := ur.db.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return nil, fail(err)
+	}
+	defer tx.Rollback(ctx)
+
+	userToken, err := createUserToken(ctx, tx, user.Id, tokenHash, models.TokenPurposeConfirmation)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fail(err)
+	}
 
-// 	return userToken, nil
-// }
+	return userToken, nil
+}
+
+// DeleteExpiredTokens removes the tokens that expired before the given
+// time, used or not.
+func (ur *userRepository) DeleteExpiredTokens(ctx context.Context, before time.Time) (int64, error) {
+	tag, err := ur.db.Exec(ctx, `DELETE FROM users_conf_tokens WHERE expires_at < $1`, before)
+	if err != nil {
+		return 0, newRepositoryError(err)
+	}
+	return tag.RowsAffected(), nil
+}
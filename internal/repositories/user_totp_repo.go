@@ -0,0 +1,161 @@
+package repositories
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/rudsonalves/quicknotes/utils"
+)
+
+// EnableTOTP stores the confirmed secret together with the hashes of the
+// recovery codes. The step of the code used to confirm it is kept, so that
+// code cannot be used again to sign in.
+func (ur *userRepository) EnableTOTP(ctx context.Context, userId int64, secret string, step int64, codeHashes []string) error {
+	tx, err := ur.db.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return fail(err)
+	}
+	defer tx.Rollback(ctx)
+
+	query := `
+	UPDATE users SET totp_secret = $2, totp_last_step = $3, updated_at = now()
+		WHERE id = $1`
+	if _, err := tx.Exec(ctx, query, userId, secret, step); err != nil {
+		return fail(err)
+	}
+
+	if err := insertRecoveryCodes(ctx, tx, userId, codeHashes); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fail(err)
+	}
+
+	return nil
+}
+
+// DisableTOTP removes the secret and the recovery codes of the user.
+func (ur *userRepository) DisableTOTP(ctx context.Context, userId int64) error {
+	tx, err := ur.db.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return fail(err)
+	}
+	defer tx.Rollback(ctx)
+
+	query := `
+	UPDATE users SET totp_secret = NULL, totp_last_step = NULL, updated_at = now()
+		WHERE id = $1`
+	if _, err := tx.Exec(ctx, query, userId); err != nil {
+		return fail(err)
+	}
+
+	if _, err := tx.Exec(ctx, `DELETE FROM user_recovery_codes WHERE user_id = $1`, userId); err != nil {
+		return fail(err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fail(err)
+	}
+
+	return nil
+}
+
+// UseTOTPStep records the time step of a valid code, telling whether it
+// is newer than the last one used. A code can only be used once.
+func (ur *userRepository) UseTOTPStep(ctx context.Context, userId, step int64) (bool, error) {
+	query := `
+	UPDATE users SET totp_last_step = $2
+		WHERE id = $1 AND totp_secret IS NOT NULL
+		AND (totp_last_step IS NULL OR totp_last_step < $2)`
+
+	tag, err := ur.db.Exec(ctx, query, userId, step)
+	if err != nil {
+		return false, newRepositoryError(err)
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// UseRecoveryCode marks the recovery code as used, telling whether it was
+// a valid code not used before. The codes are stored as bcrypt hashes, so
+// the code is compared with each unused code of the user.
+func (ur *userRepository) UseRecoveryCode(ctx context.Context, userId int64, code string) (bool, error) {
+	query := `SELECT id, code_hash FROM user_recovery_codes WHERE user_id = $1 AND used_at IS NULL`
+	rows, err := ur.db.Query(ctx, query, userId)
+	if err != nil {
+		return false, newRepositoryError(err)
+	}
+	defer rows.Close()
+
+	var codeId int64
+	for rows.Next() {
+		var id int64
+		var hash string
+		if err := rows.Scan(&id, &hash); err != nil {
+			return false, newRepositoryError(err)
+		}
+		if utils.ValidatePassword(hash, code) {
+			codeId = id
+			break
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return false, newRepositoryError(err)
+	}
+	rows.Close()
+	if codeId == 0 {
+		return false, nil
+	}
+
+	// a concurrent signin may have used the code in the meantime
+	query = `UPDATE user_recovery_codes SET used_at = now() WHERE id = $1 AND used_at IS NULL`
+	tag, err := ur.db.Exec(ctx, query, codeId)
+	if err != nil {
+		return false, newRepositoryError(err)
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// ReplaceRecoveryCodes discards the recovery codes of the user, used or
+// not, in favor of new ones.
+func (ur *userRepository) ReplaceRecoveryCodes(ctx context.Context, userId int64, codeHashes []string) error {
+	tx, err := ur.db.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return fail(err)
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, `DELETE FROM user_recovery_codes WHERE user_id = $1`, userId); err != nil {
+		return fail(err)
+	}
+
+	if err := insertRecoveryCodes(ctx, tx, userId, codeHashes); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fail(err)
+	}
+
+	return nil
+}
+
+// CountRecoveryCodes returns how many recovery codes were not used yet.
+func (ur *userRepository) CountRecoveryCodes(ctx context.Context, userId int64) (int, error) {
+	var count int
+	query := `SELECT count(*) FROM user_recovery_codes WHERE user_id = $1 AND used_at IS NULL`
+	if err := ur.db.QueryRow(ctx, query, userId).Scan(&count); err != nil {
+		return 0, newRepositoryError(err)
+	}
+	return count, nil
+}
+
+func insertRecoveryCodes(ctx context.Context, tx pgx.Tx, userId int64, codeHashes []string) error {
+	for _, hash := range codeHashes {
+		query := `INSERT INTO user_recovery_codes (user_id, code_hash) VALUES ($1, $2)`
+		if _, err := tx.Exec(ctx, query, userId, hash); err != nil {
+			return fail(err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const tempPrefix = ".upload-"
+
+type localStorage struct {
+	dir string
+}
+
+// NewLocalStorage stores files below dir, creating it when missing. Keys
+// may contain slashes, which become subdirectories.
+func NewLocalStorage(dir string) (Storage, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, err
+	}
+	return &localStorage{dir: dir}, nil
+}
+
+// path resolves key inside the storage directory, refusing keys that
+// would escape it.
+func (ls *localStorage) path(key string) (string, error) {
+	if key == "" || !filepath.IsLocal(key) || strings.HasPrefix(filepath.Base(key), tempPrefix) {
+		return "", ErrInvalidKey
+	}
+	return filepath.Join(ls.dir, filepath.FromSlash(key)), nil
+}
+
+func (ls *localStorage) Save(ctx context.Context, key string, r io.Reader) (int64, error) {
+	path, err := ls.path(key)
+	if err != nil {
+		return 0, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return 0, err
+	}
+
+	// write to a temporary file first, so readers never see half a file
+	tmp, err := os.CreateTemp(filepath.Dir(path), tempPrefix+"*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+
+	size, err := io.Copy(tmp, r)
+	if err != nil {
+		tmp.Close()
+		return 0, err
+	}
+	if err := tmp.Close(); err != nil {
+		return 0, err
+	}
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return 0, err
+	}
+	return size, nil
+}
+
+func (ls *localStorage) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	path, err := ls.path(key)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return file, err
+}
+
+func (ls *localStorage) Delete(ctx context.Context, key string) error {
+	path, err := ls.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// Walk skips the temporary files of uploads still being written.
+func (ls *localStorage) Walk(ctx context.Context, fn func(key string, modTime time.Time) error) error {
+	return filepath.WalkDir(ls.dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), tempPrefix) {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(ls.dir, path)
+		if err != nil {
+			return err
+		}
+		return fn(filepath.ToSlash(rel), info.ModTime())
+	})
+}
@@ -0,0 +1,28 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+var ErrNotFound = errors.New("stored file not found")
+var ErrInvalidKey = errors.New("invalid storage key")
+
+// Storage keeps the contents of uploaded files under opaque keys chosen by
+// the caller. Implementations must be safe for concurrent use.
+type Storage interface {
+	// Save writes the contents of r under key, returning the number of
+	// bytes written. A partially written file is never left behind.
+	Save(ctx context.Context, key string, r io.Reader) (int64, error)
+	// Open reads the file stored under key, failing with ErrNotFound when
+	// there is none.
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes the file stored under key. Deleting a missing file
+	// is not an error.
+	Delete(ctx context.Context, key string) error
+	// Walk calls fn for every stored file, with its key and the time it
+	// was last written.
+	Walk(ctx context.Context, fn func(key string, modTime time.Time) error) error
+}
@@ -2,7 +2,9 @@ package utils
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 )
 
 // gerar um enconde de uma string aleatória
@@ -11,3 +13,10 @@ func GenerateTokenKey() string {
 	rand.Read(r)
 	return base64.URLEncoding.EncodeToString(r)
 }
+
+// HashToken returns the hex encoded SHA-256 of a token, used to store
+// tokens without keeping their plain value
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}